@@ -5,10 +5,18 @@ import (
 	"flag"
 	"fmt"
 	"io/ioutil"
+	"math"
 	"os"
+	"path/filepath"
 	"strings"
+	"time"
 
+	"github.com/lisa/k8s-webhook-framework/pkg/certinjector"
+	"github.com/lisa/k8s-webhook-framework/pkg/enforcement"
+	"github.com/lisa/k8s-webhook-framework/pkg/policy"
 	"github.com/lisa/k8s-webhook-framework/pkg/webhooks"
+	"github.com/lisa/k8s-webhook-framework/pkg/webhooks/regopolicy"
+	"github.com/lisa/k8s-webhook-framework/pkg/webhooks/utils"
 	templatev1 "github.com/openshift/api/template/v1"
 	hivev1 "github.com/openshift/hive/pkg/apis/hive/v1"
 	admissionregv1 "k8s.io/api/admissionregistration/v1"
@@ -16,6 +24,7 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	rbacv1 "k8s.io/api/rbac/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/util/intstr"
@@ -24,18 +33,60 @@ import (
 	"github.com/ghodss/yaml"
 )
 
+const (
+	formatTemplate  string = "template"
+	formatYAML      string = "yaml"
+	formatKustomize string = "kustomize"
+	formatHelm      string = "helm"
+
+	injectorInit       string = "init"
+	injectorController string = "controller"
+
+	// templateImage is the image in an OpenShift Template, where the
+	// IMAGE_TAG parameter and the pipeline substitute it
+	templateImage string = "#IMG#:${IMAGE_TAG}"
+	// helmImage is the Deployment's image in a Helm chart, which comes from
+	// the chart's values
+	helmImage string = `{{ required "the image value must be set" .Values.image }}`
+	// helmFilesDir is where a Helm chart keeps the data of its ConfigMaps,
+	// which Helm would otherwise parse as templates
+	helmFilesDir string = "files"
+	// shutdownMarginSeconds is how much longer than the webhook server's drain
+	// and shutdown the kubelet waits before killing it
+	shutdownMarginSeconds int64 = 5
+)
+
 var (
 	listenPort    = flag.Int("port", 5000, "On which port should the Webhook binary listen? (Not the Service port)")
-	image         = flag.String("image", "#IMG#:${IMAGE_TAG}", "Image and tag to use for webhooks")
+	image         = flag.String("image", templateImage, "Image and tag to use for webhooks. Required with -format yaml and kustomize, the default of the image value with -format helm")
 	secretName    = flag.String("secretname", "webhook-cert", "Secret where TLS certs are created")
 	caBundleName  = flag.String("cabundlename", "webhook-cert", "ConfigMap where CA cert is created")
-	templateFile  = flag.String("outfile", "", "Path to where the SelectorSyncSet template should be written")
+	policyName    = flag.String("policyname", "webhook-policy", "ConfigMap holding the webhooks' policy")
+	outFile       = flag.String("outfile", "", "Path to where the output should be written: a file for the template and yaml formats, a directory for kustomize and helm")
+	format        = flag.String("format", formatTemplate, "Output format: template (a SelectorSyncSet in an OpenShift Template), yaml (multi-document YAML), kustomize (a Kustomize base) or helm (a Helm chart)")
+	chartVersion  = flag.String("chartversion", "0.1.0", "Version of the Helm chart written with -format helm")
 	excludes      = flag.String("exclude", "echo-hook", "Comma-separated list of webhook names to skip")
 	only          = flag.String("only", "", "Only include these comma-separated webhooks")
 	showHookNames = flag.Bool("showhooks", false, "Print registered webhook names and exit")
+	drainPeriod   = flag.Duration("drainperiod", 10*time.Second, "How long the webhook server keeps serving after SIGTERM, while failing readiness, before shutting down")
+	enforcements  = flag.String("enforcement", "", "Comma-separated webhook=mode pairs of enforcement modes (enforce, warn or audit) for the webhook server")
+	celPolicyFile = flag.String("celpolicies", "", "File of CEL policies to ship in the policy ConfigMap and generate webhook configurations for")
+	regoPolicyDir = flag.String("regopolicies", "", "Directory of Rego modules and their webhook.yaml to ship in a ConfigMap and generate a webhook configuration for. Tests aren't shipped")
+	regoName      = flag.String("regopolicyname", "webhook-rego-policies", "ConfigMap holding the Rego modules of -regopolicies")
+
+	injectorMode = flag.String("injector", injectorInit, "How the cert injector runs: init, once as an init container, or controller, as a sidecar which keeps CA bundles up to date")
+
+	certs = flag.String("certs", string(certinjector.ServiceCA), "How the serving certificate is provisioned: service-ca (OpenShift's service-ca operator), cert-manager, or self-signed (generated by the injector)")
 
 	namespace = flag.String("namespace", "openshift-validation-webhook", "In what namespace should resources exist?")
 
+	formats = []string{formatTemplate, formatYAML, formatKustomize, formatHelm}
+
+	// celPolicies is the content of -celpolicies
+	celPolicies []byte
+	// regoPolicies are the files of -regopolicies, by name
+	regoPolicies map[string]string
+
 	sssLabels = map[string]string{
 		"managed.openshift.io/gitHash":     "${IMAGE_TAG}",
 		"managed.openshift.io/gitRepoName": "${REPO_NAME}",
@@ -72,14 +123,21 @@ func createClusterRole() *rbacv1.ClusterRole {
 		Rules: []rbacv1.PolicyRule{
 			{
 				APIGroups: []string{"admissionregistration.k8s.io"},
-				Resources: []string{"validatingwebhookconfigurations"},
-				Verbs:     []string{"list", "patch", "get"},
+				Resources: []string{"validatingwebhookconfigurations", "mutatingwebhookconfigurations"},
+				Verbs:     []string{"list", "watch", "patch", "get"},
 			},
 			{
 				APIGroups: []string{""},
 				Resources: []string{"configmaps"},
 				Verbs:     []string{"list", "get"},
 			},
+			{
+				// Injection failures are reported as Events on the webhook
+				// configurations
+				APIGroups: []string{""},
+				Resources: []string{"events"},
+				Verbs:     []string{"create", "patch"},
+			},
 		},
 	}
 }
@@ -107,19 +165,114 @@ func createClusterRoleBinding() *rbacv1.ClusterRoleBinding {
 	}
 }
 func createNamespace() *corev1.Namespace {
-	return &corev1.Namespace{
+	ns := &corev1.Namespace{
 		TypeMeta: metav1.TypeMeta{
 			Kind:       "Namespace",
 			APIVersion: "v1",
 		},
 		ObjectMeta: metav1.ObjectMeta{
 			Name: *namespace,
-			Labels: map[string]string{
-				"openshift.io/cluster-monitoring": "true",
+		},
+	}
+	if isOpenShift() {
+		ns.Labels = map[string]string{
+			"openshift.io/cluster-monitoring": "true",
+		}
+	}
+	return ns
+}
+
+// isOpenShift is whether the output is for OpenShift, and so may use what
+// only OpenShift has, such as cluster monitoring. Only the template format is;
+// the others are for plain Kubernetes.
+func isOpenShift() bool {
+	return *format == formatTemplate
+}
+
+// createPrometheusRole lets the cluster monitoring Prometheus discover the
+// webhook endpoints in our namespace.
+func createPrometheusRole() *rbacv1.Role {
+	return &rbacv1.Role{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Role",
+			APIVersion: "rbac.authorization.k8s.io/v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "prometheus-k8s",
+			Namespace: *namespace,
+		},
+		Rules: []rbacv1.PolicyRule{
+			{
+				APIGroups: []string{""},
+				Resources: []string{"services", "endpoints", "pods"},
+				Verbs:     []string{"get", "list", "watch"},
 			},
 		},
 	}
 }
+
+func createPrometheusRoleBinding() *rbacv1.RoleBinding {
+	return &rbacv1.RoleBinding{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "RoleBinding",
+			APIVersion: "rbac.authorization.k8s.io/v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "prometheus-k8s",
+			Namespace: *namespace,
+		},
+		RoleRef: rbacv1.RoleRef{
+			APIGroup: "rbac.authorization.k8s.io",
+			Kind:     "Role",
+			Name:     "prometheus-k8s",
+		},
+		Subjects: []rbacv1.Subject{
+			{
+				Kind:      "ServiceAccount",
+				Name:      "prometheus-k8s",
+				Namespace: "openshift-monitoring",
+			},
+		},
+	}
+}
+
+// createServiceMonitor has cluster monitoring scrape the webhooks' /metrics
+// endpoint. It is unstructured to avoid depending on the prometheus-operator
+// API types.
+func createServiceMonitor() *unstructured.Unstructured {
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "monitoring.coreos.com/v1",
+			"kind":       "ServiceMonitor",
+			"metadata": map[string]interface{}{
+				"name":      "validation-webhook",
+				"namespace": *namespace,
+			},
+			"spec": map[string]interface{}{
+				"endpoints": []interface{}{
+					map[string]interface{}{
+						"port":   "https",
+						"path":   "/metrics",
+						"scheme": "https",
+						"tlsConfig": map[string]interface{}{
+							"caFile":     "/etc/prometheus/configmaps/serving-certs-ca-bundle/service-ca.crt",
+							"serverName": fmt.Sprintf("validation-webhook.%s.svc", *namespace),
+						},
+					},
+				},
+				"namespaceSelector": map[string]interface{}{
+					"matchNames": []interface{}{*namespace},
+				},
+				"selector": map[string]interface{}{
+					"matchLabels": map[string]interface{}{
+						"name": "validation-webhook",
+					},
+				},
+			},
+		},
+	}
+}
+
 func createCACertConfigMap() *corev1.ConfigMap {
 	return &corev1.ConfigMap{
 		TypeMeta: metav1.TypeMeta{
@@ -136,7 +289,124 @@ func createCACertConfigMap() *corev1.ConfigMap {
 	}
 }
 
-func createDeployment() *appsv1.Deployment {
+// terminationGracePeriodSeconds gives the webhook server time to drain and
+// then, like its Shutdown, to finish requests for as long as the slowest
+// hook's TimeoutSeconds, so that the kubelet doesn't kill it halfway.
+func terminationGracePeriodSeconds() int64 {
+	var timeout int32
+	for _, hook := range webhooks.Webhooks {
+		if t := hook().TimeoutSeconds(); t > timeout {
+			timeout = t
+		}
+	}
+	if timeout == 0 {
+		// The server's timeout without hooks, the longest the API server allows
+		timeout = 30
+	}
+	return int64(math.Ceil(drainPeriod.Seconds())) + int64(timeout) + shutdownMarginSeconds
+}
+
+// createPolicyConfigMap renders the default policy, which fleets may then edit
+// in the template.
+func createPolicyConfigMap() *corev1.ConfigMap {
+	data, err := yaml.Marshal(policy.Default())
+	if err != nil {
+		panic(err.Error())
+	}
+	cm := &corev1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "ConfigMap",
+			APIVersion: "v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      *policyName,
+			Namespace: *namespace,
+		},
+		Data: map[string]string{
+			"policy.yaml": string(data),
+		},
+	}
+	if celPolicies != nil {
+		cm.Data["cel-policies.yaml"] = string(celPolicies)
+	}
+	return cm
+}
+
+// createRegoPolicyConfigMap ships the Rego modules of -regopolicies, which the
+// webhook server reloads when the ConfigMap is edited.
+func createRegoPolicyConfigMap() *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "ConfigMap",
+			APIVersion: "v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      *regoName,
+			Namespace: *namespace,
+		},
+		Data: regoPolicies,
+	}
+}
+
+// readRegoPolicies reads the files of the Rego policy directory dir, except
+// for tests. Subdirectories can't be shipped in a ConfigMap and are errors.
+func readRegoPolicies(dir string) (map[string]string, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	ret := make(map[string]string)
+	for _, file := range files {
+		if file.IsDir() {
+			return nil, fmt.Errorf("%s is a directory, which can't be shipped in a ConfigMap", filepath.Join(dir, file.Name()))
+		}
+		if strings.HasSuffix(file.Name(), regopolicy.TestSuffix) {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		ret[file.Name()] = string(data)
+	}
+	return ret, nil
+}
+
+func createDeployment(modes map[string]enforcement.Mode) *appsv1.Deployment {
+	volumes, mounts, initContainers, caCert := createCertVolumes()
+	command := []string{
+		"webhooks",
+		"-tlskey", "/service-certs/tls.key",
+		"-tlscert", "/service-certs/tls.crt",
+		"-cacert", caCert,
+		"-tls",
+		"-drainperiod", drainPeriod.String(),
+		"-policy", "/policy/policy.yaml",
+	}
+	if celPolicies != nil {
+		command = append(command, "-celpolicies", "/policy/cel-policies.yaml")
+	}
+	if regoPolicies != nil {
+		command = append(command, "-regopolicies", "/rego")
+		volumes = append(volumes, corev1.Volume{
+			Name: "rego-policies",
+			VolumeSource: corev1.VolumeSource{
+				ConfigMap: &corev1.ConfigMapVolumeSource{
+					LocalObjectReference: corev1.LocalObjectReference{
+						Name: *regoName,
+					},
+				},
+			},
+		})
+		mounts = append(mounts, corev1.VolumeMount{
+			Name:      "rego-policies",
+			MountPath: "/rego",
+			ReadOnly:  true,
+		})
+	}
+	if len(modes) > 0 {
+		command = append(command, "-enforcement", enforcement.FormatModes(modes))
+	}
 	return &appsv1.Deployment{
 		TypeMeta: metav1.TypeMeta{
 			Kind:       "Deployment",
@@ -164,72 +434,388 @@ func createDeployment() *appsv1.Deployment {
 					},
 				},
 				Spec: corev1.PodSpec{
-					ServiceAccountName: "validation-webhook",
-					RestartPolicy:      corev1.RestartPolicyAlways,
-					Volumes: []corev1.Volume{
-						{
-							Name: "service-certs",
-							VolumeSource: corev1.VolumeSource{
-								Secret: &corev1.SecretVolumeSource{
-									SecretName: *secretName,
+					ServiceAccountName:            "validation-webhook",
+					RestartPolicy:                 corev1.RestartPolicyAlways,
+					TerminationGracePeriodSeconds: pointer.Int64Ptr(terminationGracePeriodSeconds()),
+					Volumes: append(volumes, corev1.Volume{
+						Name: "policy",
+						VolumeSource: corev1.VolumeSource{
+							ConfigMap: &corev1.ConfigMapVolumeSource{
+								LocalObjectReference: corev1.LocalObjectReference{
+									Name: *policyName,
 								},
 							},
 						},
-						{
-							Name: "service-ca",
-							VolumeSource: corev1.VolumeSource{
-								ConfigMap: &corev1.ConfigMapVolumeSource{
-									LocalObjectReference: corev1.LocalObjectReference{
-										Name: *caBundleName,
-									},
-								},
-							},
-						},
-					},
-					InitContainers: []corev1.Container{
-						{
-							Image: *image,
-							Name:  "inject-cert",
-							Command: []string{
-								"injector",
-							},
-						},
-					},
-					Containers: []corev1.Container{
+					}),
+					InitContainers: initContainers,
+					Containers: append([]corev1.Container{
 						{
 							ImagePullPolicy: corev1.PullAlways,
 							Name:            "webhooks",
 							Image:           *image,
-							VolumeMounts: []corev1.VolumeMount{
-								{
-									Name:      "service-certs",
-									MountPath: "/service-certs",
-									ReadOnly:  true,
-								},
-								{
-									Name:      "service-ca",
-									MountPath: "/service-ca",
-									ReadOnly:  true,
-								},
-							},
+							VolumeMounts: append(mounts, corev1.VolumeMount{
+								Name:      "policy",
+								MountPath: "/policy",
+								ReadOnly:  true,
+							}),
 							Ports: []corev1.ContainerPort{
 								{
 									ContainerPort: int32(*listenPort),
 								},
 							},
-							Command: []string{
-								"webhooks",
-								"-tlskey", "/service-certs/tls.key",
-								"-tlscert", "/service-certs/tls.crt",
-								"-cacert", "/service-ca/service-ca.crt",
-								"-tls",
+							LivenessProbe: &corev1.Probe{
+								Handler: corev1.Handler{
+									HTTPGet: &corev1.HTTPGetAction{
+										Path:   "/healthz",
+										Port:   intstr.FromInt(*listenPort),
+										Scheme: corev1.URISchemeHTTPS,
+									},
+								},
+								InitialDelaySeconds: 10,
+								PeriodSeconds:       10,
+								FailureThreshold:    3,
+							},
+							// Not ready until the serving certificates are loaded
+							ReadinessProbe: &corev1.Probe{
+								Handler: corev1.Handler{
+									HTTPGet: &corev1.HTTPGetAction{
+										Path:   "/readyz",
+										Port:   intstr.FromInt(*listenPort),
+										Scheme: corev1.URISchemeHTTPS,
+									},
+								},
+								PeriodSeconds:    5,
+								FailureThreshold: 1,
 							},
+							Command: command,
 						},
+					}, createInjectorSidecars()...),
+				},
+			},
+		},
+	}
+}
+
+// createCertVolumes returns what the Deployment needs for the serving
+// certificate to be provisioned with -certs: the volumes holding it, where the
+// webhook server mounts them, the init containers which set them up and the
+// path of the CA bundle. The certificate and key are always in
+// /service-certs.
+func createCertVolumes() ([]corev1.Volume, []corev1.VolumeMount, []corev1.Container, string) {
+	certsMount := corev1.VolumeMount{
+		Name:      "service-certs",
+		MountPath: "/service-certs",
+		ReadOnly:  true,
+	}
+	secretVolume := corev1.Volume{
+		Name: "service-certs",
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{
+				SecretName: *secretName,
+			},
+		},
+	}
+
+	switch certinjector.Provisioning(*certs) {
+	case certinjector.CertManager:
+		// cert-manager puts the CA alongside the certificate
+		return []corev1.Volume{secretVolume}, []corev1.VolumeMount{certsMount}, nil, "/service-certs/ca.crt"
+	case certinjector.SelfSigned:
+		// The injector writes the certificates it keeps in the Secret into a
+		// volume shared with the webhook server, as the Secret may not exist
+		// before it runs. With -injector controller its sidecar renews them
+		// there; otherwise pods only pick up renewed certificates when they
+		// restart, which they must within 30 days of the renewal, before the
+		// old ones expire.
+		volumes := []corev1.Volume{
+			{
+				Name: "service-certs",
+				VolumeSource: corev1.VolumeSource{
+					EmptyDir: &corev1.EmptyDirVolumeSource{},
+				},
+			},
+		}
+		initContainers := []corev1.Container{
+			{
+				Image: *image,
+				Name:  "inject-cert",
+				Command: []string{
+					"injector",
+					"-certs", string(certinjector.SelfSigned),
+					"-namespace", *namespace,
+					"-secretname", *secretName,
+					"-service", "validation-webhook",
+					"-certdir", "/service-certs",
+				},
+				VolumeMounts: []corev1.VolumeMount{
+					{
+						Name:      "service-certs",
+						MountPath: "/service-certs",
+					},
+				},
+			},
+		}
+		return volumes, []corev1.VolumeMount{certsMount}, initContainers, "/service-certs/ca.crt"
+	}
+
+	volumes := []corev1.Volume{
+		secretVolume,
+		{
+			Name: "service-ca",
+			VolumeSource: corev1.VolumeSource{
+				ConfigMap: &corev1.ConfigMapVolumeSource{
+					LocalObjectReference: corev1.LocalObjectReference{
+						Name: *caBundleName,
 					},
 				},
 			},
 		},
 	}
+	mounts := []corev1.VolumeMount{
+		certsMount,
+		{
+			Name:      "service-ca",
+			MountPath: "/service-ca",
+			ReadOnly:  true,
+		},
+	}
+	if *injectorMode == injectorController {
+		// The sidecar takes care of it
+		return volumes, mounts, nil, "/service-ca/service-ca.crt"
+	}
+	initContainers := []corev1.Container{
+		{
+			Image: *image,
+			Name:  "inject-cert",
+			Command: []string{
+				"injector",
+			},
+		},
+	}
+	return volumes, mounts, initContainers, "/service-ca/service-ca.crt"
+}
+
+// createInjectorSidecars returns the cert injector's controller container,
+// when it runs as one
+func createInjectorSidecars() []corev1.Container {
+	if *injectorMode != injectorController || certinjector.Provisioning(*certs) == certinjector.CertManager {
+		return nil
+	}
+	sidecar := corev1.Container{
+		Image: *image,
+		Name:  "cert-injector",
+		Command: []string{
+			"injector",
+			"-controller",
+			"-namespace", *namespace,
+		},
+	}
+	if certinjector.Provisioning(*certs) == certinjector.SelfSigned {
+		// The sidecar renews the certificates the webhook server reads
+		sidecar.Command = append(sidecar.Command,
+			"-certs", string(certinjector.SelfSigned),
+			"-secretname", *secretName,
+			"-service", "validation-webhook",
+			"-certdir", "/service-certs",
+		)
+		sidecar.VolumeMounts = []corev1.VolumeMount{
+			{
+				Name:      "service-certs",
+				MountPath: "/service-certs",
+			},
+		}
+	}
+	return []corev1.Container{sidecar}
+}
+
+// createInjectorRole lets the cert injector's controller watch for CA changes
+// in our namespace and elect a leader. CA sources in other namespaces need the
+// same access to their ConfigMaps or Secrets there.
+func createInjectorRole() *rbacv1.Role {
+	return &rbacv1.Role{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Role",
+			APIVersion: "rbac.authorization.k8s.io/v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "webhook-cert-injector",
+			Namespace: *namespace,
+		},
+		Rules: []rbacv1.PolicyRule{
+			{
+				APIGroups: []string{""},
+				Resources: []string{"configmaps", "secrets"},
+				Verbs:     []string{"get", "list", "watch"},
+			},
+			{
+				APIGroups: []string{"coordination.k8s.io"},
+				Resources: []string{"leases"},
+				Verbs:     []string{"create"},
+			},
+			{
+				APIGroups:     []string{"coordination.k8s.io"},
+				Resources:     []string{"leases"},
+				ResourceNames: []string{certinjector.LeaseName},
+				Verbs:         []string{"get", "update"},
+			},
+		},
+	}
+}
+
+func createInjectorRoleBinding() *rbacv1.RoleBinding {
+	return &rbacv1.RoleBinding{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "RoleBinding",
+			APIVersion: "rbac.authorization.k8s.io/v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "webhook-cert-injector",
+			Namespace: *namespace,
+		},
+		RoleRef: rbacv1.RoleRef{
+			APIGroup: "rbac.authorization.k8s.io",
+			Kind:     "Role",
+			Name:     "webhook-cert-injector",
+		},
+		Subjects: []rbacv1.Subject{
+			{
+				Kind:      "ServiceAccount",
+				Name:      "validation-webhook",
+				Namespace: *namespace,
+			},
+		},
+	}
+}
+
+// serviceAnnotations has the service-ca operator issue the serving
+// certificate, when it is used
+func serviceAnnotations() map[string]string {
+	if certinjector.Provisioning(*certs) != certinjector.ServiceCA {
+		return nil
+	}
+	return map[string]string{
+		"service.beta.openshift.io/serving-cert-secret-name": *secretName,
+	}
+}
+
+// caInjectionAnnotations tell whoever injects the CA bundle into the webhook
+// configurations where to find it
+func caInjectionAnnotations() map[string]string {
+	switch certinjector.Provisioning(*certs) {
+	case certinjector.CertManager:
+		return map[string]string{
+			certinjector.CertManagerInjectAnnotation: fmt.Sprintf("%s/validation-webhook", *namespace),
+		}
+	case certinjector.SelfSigned:
+		// The previous CA is trusted too, for replicas which haven't yet
+		// picked up a certificate reissued by a new one
+		return map[string]string{
+			certinjector.InjectFromSecretAnnotation: fmt.Sprintf("%s/%s/%s,%s/%s/%s",
+				*namespace, *secretName, certinjector.CACertKey,
+				*namespace, *secretName, certinjector.PreviousCACertKey),
+		}
+	}
+	return map[string]string{
+		certinjector.InjectFromAnnotation: fmt.Sprintf("%s/%s", *namespace, *caBundleName),
+	}
+}
+
+// createCertManagerIssuer creates the self-signed cert-manager Issuer of the
+// serving certificate
+func createCertManagerIssuer() *unstructured.Unstructured {
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "cert-manager.io/v1",
+			"kind":       "Issuer",
+			"metadata": map[string]interface{}{
+				"name":      "validation-webhook",
+				"namespace": *namespace,
+			},
+			"spec": map[string]interface{}{
+				"selfSigned": map[string]interface{}{},
+			},
+		},
+	}
+}
+
+// createCertManagerCertificate has cert-manager issue the serving certificate
+// into the Secret the webhook server mounts
+func createCertManagerCertificate() *unstructured.Unstructured {
+	dnsNames := make([]interface{}, 0)
+	for _, name := range certinjector.SelfSignedDNSNames("validation-webhook", *namespace) {
+		dnsNames = append(dnsNames, name)
+	}
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "cert-manager.io/v1",
+			"kind":       "Certificate",
+			"metadata": map[string]interface{}{
+				"name":      "validation-webhook",
+				"namespace": *namespace,
+			},
+			"spec": map[string]interface{}{
+				"secretName": *secretName,
+				"dnsNames":   dnsNames,
+				"issuerRef": map[string]interface{}{
+					"name": "validation-webhook",
+					"kind": "Issuer",
+				},
+			},
+		},
+	}
+}
+
+// createSecretsRole lets the injector keep self-signed certificates in a
+// Secret in our namespace
+func createSecretsRole() *rbacv1.Role {
+	return &rbacv1.Role{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Role",
+			APIVersion: "rbac.authorization.k8s.io/v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "webhook-cert",
+			Namespace: *namespace,
+		},
+		Rules: []rbacv1.PolicyRule{
+			{
+				APIGroups: []string{""},
+				Resources: []string{"secrets"},
+				Verbs:     []string{"create"},
+			},
+			{
+				APIGroups:     []string{""},
+				Resources:     []string{"secrets"},
+				ResourceNames: []string{*secretName},
+				Verbs:         []string{"get", "update"},
+			},
+		},
+	}
+}
+
+func createSecretsRoleBinding() *rbacv1.RoleBinding {
+	return &rbacv1.RoleBinding{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "RoleBinding",
+			APIVersion: "rbac.authorization.k8s.io/v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "webhook-cert",
+			Namespace: *namespace,
+		},
+		RoleRef: rbacv1.RoleRef{
+			APIGroup: "rbac.authorization.k8s.io",
+			Kind:     "Role",
+			Name:     "webhook-cert",
+		},
+		Subjects: []rbacv1.Subject{
+			{
+				Kind:      "ServiceAccount",
+				Name:      "validation-webhook",
+				Namespace: *namespace,
+			},
+		},
+	}
 }
 
 func createService() *corev1.Service {
@@ -239,9 +825,7 @@ func createService() *corev1.Service {
 			APIVersion: "v1",
 		},
 		ObjectMeta: metav1.ObjectMeta{
-			Annotations: map[string]string{
-				"service.beta.openshift.io/serving-cert-secret-name": *secretName,
-			},
+			Annotations: serviceAnnotations(),
 			Labels: map[string]string{
 				"name": "validation-webhook",
 			},
@@ -280,10 +864,8 @@ func createValidatingWebhookConfiguration(hook webhooks.Webhook) admissionregv1.
 			APIVersion: "admissionregistration.k8s.io/v1",
 		},
 		ObjectMeta: metav1.ObjectMeta{
-			Name: fmt.Sprintf("sre-%s", hook.Name()),
-			Annotations: map[string]string{
-				"managed.openshift.io/inject-cabundle-from": fmt.Sprintf("%s/webhook-cert", *namespace),
-			},
+			Name:        fmt.Sprintf("sre-%s", hook.Name()),
+			Annotations: caInjectionAnnotations(),
 		},
 		Webhooks: []admissionregv1.ValidatingWebhook{
 			{
@@ -299,12 +881,69 @@ func createValidatingWebhookConfiguration(hook webhooks.Webhook) admissionregv1.
 						Name:      hook.Name(),
 					},
 				},
-				Rules: hook.Rules(),
+				Rules:             hook.Rules(),
+				NamespaceSelector: hook.NamespaceSelector(),
+				ObjectSelector:    hook.ObjectSelector(),
+				// The webhook server answers in whichever of these the API server
+				// sends, preferring v1.
+				AdmissionReviewVersions: []string{"v1", "v1beta1"},
+			},
+		},
+	}
+}
+
+// createMutatingWebhookConfiguration turns a MutatingWebhook into a
+// MutatingWebhookConfiguration, the mutating counterpart to
+// createValidatingWebhookConfiguration.
+func createMutatingWebhookConfiguration(hook webhooks.MutatingWebhook) admissionregv1.MutatingWebhookConfiguration {
+	failPolicy := hook.FailurePolicy()
+	timeout := hook.TimeoutSeconds()
+
+	return admissionregv1.MutatingWebhookConfiguration{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "MutatingWebhookConfiguration",
+			APIVersion: "admissionregistration.k8s.io/v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        fmt.Sprintf("sre-%s", hook.Name()),
+			Annotations: caInjectionAnnotations(),
+		},
+		Webhooks: []admissionregv1.MutatingWebhook{
+			{
+				TimeoutSeconds:     &timeout,
+				SideEffects:        hook.SideEffects(),
+				MatchPolicy:        hook.MatchPolicy(),
+				ReinvocationPolicy: hook.ReinvocationPolicy(),
+				Name:               fmt.Sprintf("%s.managed.openshift.io", hook.Name()),
+				FailurePolicy:      &failPolicy,
+				ClientConfig: admissionregv1.WebhookClientConfig{
+					Service: &admissionregv1.ServiceReference{
+						Namespace: *namespace,
+						Path:      pointer.StringPtr(hook.GetURI()),
+						Name:      hook.Name(),
+					},
+				},
+				Rules:             hook.Rules(),
+				NamespaceSelector: hook.NamespaceSelector(),
+				ObjectSelector:    hook.ObjectSelector(),
+				// The webhook server answers in whichever of these the API server
+				// sends, preferring v1.
+				AdmissionReviewVersions: []string{"v1", "v1beta1"},
 			},
 		},
 	}
 }
 
+// createWebhookConfiguration renders hook as a MutatingWebhookConfiguration
+// if it implements webhooks.MutatingWebhook, otherwise as a
+// ValidatingWebhookConfiguration.
+func createWebhookConfiguration(hook webhooks.Webhook) interface{} {
+	if mutatingHook, ok := hook.(webhooks.MutatingWebhook); ok {
+		return createMutatingWebhookConfiguration(mutatingHook)
+	}
+	return createValidatingWebhookConfiguration(hook)
+}
+
 func encode(obj interface{}) []byte {
 	o, err := json.Marshal(obj)
 	if err != nil {
@@ -314,15 +953,6 @@ func encode(obj interface{}) []byte {
 	return o
 }
 
-func sliceContains(needle string, haystack []string) bool {
-	for _, hay := range haystack {
-		if hay == needle {
-			return true
-		}
-	}
-	return false
-}
-
 func createSelectorSyncSet(resources []runtime.RawExtension) *hivev1.SelectorSyncSet {
 	return &hivev1.SelectorSyncSet{
 		TypeMeta: metav1.TypeMeta{
@@ -353,17 +983,106 @@ func createSelectorSyncSet(resources []runtime.RawExtension) *hivev1.SelectorSyn
 func main() {
 	flag.Parse()
 
+	if !utils.SliceContains(*format, formats) {
+		fmt.Printf("Unknown -format %s, expected one of %s\n", *format, strings.Join(formats, ", "))
+		os.Exit(1)
+	}
+	imageSet := false
+	flag.Visit(func(f *flag.Flag) {
+		imageSet = imageSet || f.Name == "image"
+	})
+	imageValue := ""
+	switch *format {
+	case formatYAML, formatKustomize:
+		// Nothing substitutes the template's image outside of it
+		if !imageSet {
+			fmt.Printf("Expected -image option with -format %s\n", *format)
+			os.Exit(1)
+		}
+	case formatHelm:
+		if imageSet {
+			imageValue = *image
+		}
+		*image = helmImage
+	}
+
+	if *injectorMode != injectorInit && *injectorMode != injectorController {
+		fmt.Printf("Unknown -injector %s, expected %s or %s\n", *injectorMode, injectorInit, injectorController)
+		os.Exit(1)
+	}
+	if _, err := certinjector.ParseProvisioning(*certs); err != nil {
+		fmt.Printf("Couldn't parse -certs: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	if *celPolicyFile != "" {
+		if err := webhooks.RegisterCELPolicies(*celPolicyFile); err != nil {
+			fmt.Printf("Couldn't load -celpolicies: %s\n", err.Error())
+			os.Exit(1)
+		}
+		var err error
+		if celPolicies, err = ioutil.ReadFile(*celPolicyFile); err != nil {
+			fmt.Printf("Couldn't read -celpolicies: %s\n", err.Error())
+			os.Exit(1)
+		}
+	}
+	if *regoPolicyDir != "" {
+		if _, err := webhooks.RegisterRegoPolicy(*regoPolicyDir); err != nil {
+			fmt.Printf("Couldn't load -regopolicies: %s\n", err.Error())
+			os.Exit(1)
+		}
+		var err error
+		if regoPolicies, err = readRegoPolicies(*regoPolicyDir); err != nil {
+			fmt.Printf("Couldn't read -regopolicies: %s\n", err.Error())
+			os.Exit(1)
+		}
+	}
+
 	skip := strings.Split(*excludes, ",")
 	onlyInclude := strings.Split(*only, "")
+	modes, err := enforcement.ParseModes(*enforcements)
+	if err != nil {
+		fmt.Printf("Couldn't parse -enforcement: %s\n", err.Error())
+		os.Exit(1)
+	}
+	for name := range modes {
+		if _, ok := webhooks.Webhooks[name]; !ok {
+			fmt.Printf("Couldn't parse -enforcement: no webhook named %s\n", name)
+			os.Exit(1)
+		}
+	}
 
 	encoded := make([]runtime.RawExtension, 0)
 	encoded = append(encoded, runtime.RawExtension{Object: createNamespace()})
 	encoded = append(encoded, runtime.RawExtension{Object: createServiceAccount()})
 	encoded = append(encoded, runtime.RawExtension{Object: createClusterRole()})
 	encoded = append(encoded, runtime.RawExtension{Object: createClusterRoleBinding()})
-	encoded = append(encoded, runtime.RawExtension{Object: createCACertConfigMap()})
+	if *injectorMode == injectorController {
+		encoded = append(encoded, runtime.RawExtension{Object: createInjectorRole()})
+		encoded = append(encoded, runtime.RawExtension{Object: createInjectorRoleBinding()})
+	}
+	switch certinjector.Provisioning(*certs) {
+	case certinjector.ServiceCA:
+		encoded = append(encoded, runtime.RawExtension{Object: createCACertConfigMap()})
+	case certinjector.CertManager:
+		encoded = append(encoded, runtime.RawExtension{Object: createCertManagerIssuer()})
+		encoded = append(encoded, runtime.RawExtension{Object: createCertManagerCertificate()})
+	case certinjector.SelfSigned:
+		encoded = append(encoded, runtime.RawExtension{Object: createSecretsRole()})
+		encoded = append(encoded, runtime.RawExtension{Object: createSecretsRoleBinding()})
+	}
+	encoded = append(encoded, runtime.RawExtension{Object: createPolicyConfigMap()})
+	if regoPolicies != nil {
+		encoded = append(encoded, runtime.RawExtension{Object: createRegoPolicyConfigMap()})
+	}
 	encoded = append(encoded, runtime.RawExtension{Object: createService()})
-	encoded = append(encoded, runtime.RawExtension{Object: createDeployment()})
+	encoded = append(encoded, runtime.RawExtension{Object: createDeployment(modes)})
+	if isOpenShift() {
+		// Cluster monitoring, which plain Kubernetes lacks
+		encoded = append(encoded, runtime.RawExtension{Object: createPrometheusRole()})
+		encoded = append(encoded, runtime.RawExtension{Object: createPrometheusRoleBinding()})
+		encoded = append(encoded, runtime.RawExtension{Object: createServiceMonitor()})
+	}
 	for _, hook := range webhooks.Webhooks {
 		// no rules...?
 		if len(hook().Rules()) == 0 {
@@ -373,28 +1092,46 @@ func main() {
 		if *showHookNames {
 			fmt.Println(hook().Name())
 		}
-		if sliceContains(hook().Name(), skip) {
+		if utils.SliceContains(hook().Name(), skip) {
 			continue
 		}
 		if len(onlyInclude) > 0 {
-			if sliceContains(hook().Name(), onlyInclude) {
-				encoded = append(encoded, runtime.RawExtension{Raw: encode(createValidatingWebhookConfiguration(hook()))})
+			if utils.SliceContains(hook().Name(), onlyInclude) {
+				encoded = append(encoded, runtime.RawExtension{Raw: encode(createWebhookConfiguration(hook()))})
 			}
 			continue
 		}
 		// can't use RawExtension{Object: } here because the VWC doesn't implement DeepCopyObject
-		encoded = append(encoded, runtime.RawExtension{Raw: encode(createValidatingWebhookConfiguration(hook()))})
+		encoded = append(encoded, runtime.RawExtension{Raw: encode(createWebhookConfiguration(hook()))})
 	}
 	if *showHookNames {
 		os.Exit(0)
 	}
-	if *templateFile == "" {
+	if *outFile == "" {
 		fmt.Printf("Expected -outfile option\n\n")
 		flag.Usage()
 		os.Exit(1)
 	}
 
-	sss := createSelectorSyncSet(encoded)
+	switch *format {
+	case formatTemplate:
+		err = writeTemplate(*outFile, encoded)
+	case formatYAML:
+		err = writeYAML(*outFile, encoded)
+	case formatKustomize:
+		err = writeKustomize(*outFile, encoded)
+	case formatHelm:
+		err = writeHelmChart(*outFile, encoded, imageValue)
+	}
+	if err != nil {
+		fmt.Printf("Failed to write %s output to %s: %s\n", *format, *outFile, err.Error())
+		os.Exit(1)
+	}
+}
+
+// writeTemplate writes resources as a SelectorSyncSet in an OpenShift Template
+func writeTemplate(path string, resources []runtime.RawExtension) error {
+	sss := createSelectorSyncSet(resources)
 
 	te := templatev1.Template{
 		TypeMeta: metav1.TypeMeta{
@@ -424,11 +1161,148 @@ func main() {
 
 	y, err := yaml.Marshal(te)
 	if err != nil {
-		fmt.Printf("couldn't marshal: %s\n", err.Error())
+		return err
+	}
+	return ioutil.WriteFile(path, y, 0644)
+}
+
+// manifest is a resource as YAML, along with the name of the file it is
+// written to in a Kustomize base or Helm chart
+type manifest struct {
+	fileName string
+	yaml     []byte
+}
+
+func toManifests(resources []runtime.RawExtension) ([]manifest, error) {
+	ret := make([]manifest, 0, len(resources))
+	for _, resource := range resources {
+		raw := resource.Raw
+		if raw == nil {
+			raw = encode(resource.Object)
+		}
+		meta := metav1.PartialObjectMetadata{}
+		if err := json.Unmarshal(raw, &meta); err != nil {
+			return nil, err
+		}
+		y, err := yaml.JSONToYAML(raw)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, manifest{
+			fileName: fmt.Sprintf("%s-%s.yaml", strings.ToLower(meta.Kind), meta.Name),
+			yaml:     y,
+		})
+	}
+	return ret, nil
+}
+
+// writeYAML writes resources to path as one multi-document YAML file
+func writeYAML(path string, resources []runtime.RawExtension) error {
+	manifests, err := toManifests(resources)
+	if err != nil {
+		return err
+	}
+	docs := make([]string, 0, len(manifests))
+	for _, m := range manifests {
+		docs = append(docs, string(m.yaml))
+	}
+	return ioutil.WriteFile(path, []byte("---\n"+strings.Join(docs, "---\n")), 0644)
+}
+
+// writeKustomize writes resources to the directory dir as a Kustomize base:
+// one file per resource, listed in kustomization.yaml
+func writeKustomize(dir string, resources []runtime.RawExtension) error {
+	manifests, err := toManifests(resources)
+	if err != nil {
+		return err
+	}
+	fileNames, err := writeManifests(dir, manifests)
+	if err != nil {
+		return err
+	}
+	kustomization := map[string]interface{}{
+		"apiVersion": "kustomize.config.k8s.io/v1beta1",
+		"kind":       "Kustomization",
+		"resources":  fileNames,
+	}
+	return writeYAMLFile(filepath.Join(dir, "kustomization.yaml"), kustomization)
+}
+
+// writeHelmChart writes resources to the directory dir as a Helm chart, with
+// one template per resource. image is the default of the chart's image value,
+// which must be set on install when it is empty.
+// The data of ConfigMaps, such as policies, are written to files of the chart
+// which their templates include, so that Helm doesn't parse them as templates.
+func writeHelmChart(dir string, resources []runtime.RawExtension, image string) error {
+	resources = append([]runtime.RawExtension{}, resources...)
+	// includes are the template lines including the files of the ConfigMap
+	// at the same index in resources
+	includes := make(map[int]string)
+	for i, resource := range resources {
+		cm, ok := resource.Object.(*corev1.ConfigMap)
+		if !ok || len(cm.Data) == 0 {
+			continue
+		}
+		filesDir := filepath.Join(dir, helmFilesDir, cm.Name)
+		if err := os.MkdirAll(filesDir, 0755); err != nil {
+			return err
+		}
+		for key, value := range cm.Data {
+			if err := ioutil.WriteFile(filepath.Join(filesDir, key), []byte(value), 0644); err != nil {
+				return err
+			}
+		}
+		cm = cm.DeepCopy()
+		cm.Data = nil
+		resources[i] = runtime.RawExtension{Object: cm}
+		includes[i] = fmt.Sprintf("data:\n{{ (.Files.Glob %q).AsConfig | indent 2 }}\n", helmFilesDir+"/"+cm.Name+"/*")
+	}
+	manifests, err := toManifests(resources)
+	if err != nil {
+		return err
 	}
+	for i, include := range includes {
+		manifests[i].yaml = append(manifests[i].yaml, include...)
+	}
+	if _, err := writeManifests(filepath.Join(dir, "templates"), manifests); err != nil {
+		return err
+	}
+	chart := map[string]interface{}{
+		"apiVersion":  "v2",
+		"name":        "managed-cluster-validating-webhooks",
+		"description": "Validating webhooks for managed clusters",
+		"type":        "application",
+		"version":     *chartVersion,
+	}
+	if err := writeYAMLFile(filepath.Join(dir, "Chart.yaml"), chart); err != nil {
+		return err
+	}
+	values := map[string]interface{}{
+		"image": image,
+	}
+	return writeYAMLFile(filepath.Join(dir, "values.yaml"), values)
+}
+
+// writeManifests writes each manifest to its own file in dir, creating dir if
+// need be, and returns the names of the files in order
+func writeManifests(dir string, manifests []manifest) ([]string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	fileNames := make([]string, 0, len(manifests))
+	for _, m := range manifests {
+		if err := ioutil.WriteFile(filepath.Join(dir, m.fileName), m.yaml, 0644); err != nil {
+			return nil, err
+		}
+		fileNames = append(fileNames, m.fileName)
+	}
+	return fileNames, nil
+}
 
-	err = ioutil.WriteFile(*templateFile, y, 0644)
+func writeYAMLFile(path string, obj interface{}) error {
+	y, err := yaml.Marshal(obj)
 	if err != nil {
-		fmt.Printf("Failed to write to %s: %s\n", *templateFile, err.Error())
+		return err
 	}
+	return ioutil.WriteFile(path, y, 0644)
 }
@@ -0,0 +1,221 @@
+// evaluate runs an AdmissionReview through every webhook which would receive
+// it, without a cluster or a server, and prints each webhook's verdict. The
+// review is read from a file or stdin, eg:
+//
+//	go run ./cmd/evaluate -f fixtures/groups/update-customer-group.json
+//	kubectl get --raw ... | go run ./cmd/evaluate -o json
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"k8s.io/api/admission/v1beta1"
+	"k8s.io/apimachinery/pkg/labels"
+	admissionctl "sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	"github.com/lisa/k8s-webhook-framework/pkg/enforcement"
+	"github.com/lisa/k8s-webhook-framework/pkg/metrics"
+	"github.com/lisa/k8s-webhook-framework/pkg/policy"
+	"github.com/lisa/k8s-webhook-framework/pkg/webhooks"
+	"github.com/lisa/k8s-webhook-framework/pkg/webhooks/utils"
+)
+
+var (
+	inputFile        = flag.String("f", "-", "AdmissionReview (admission.k8s.io/v1 or v1beta1) to evaluate, or - for stdin")
+	output           = flag.String("o", "table", "Output format: table or json")
+	policyFile       = flag.String("policy", "", "Policy file naming privileged users, groups and namespaces. Built-in defaults are used if empty")
+	celPolicyFile    = flag.String("celpolicies", "", "File of CEL policies to evaluate alongside the built-in webhooks")
+	regoPolicyDir    = flag.String("regopolicies", "", "Directory of Rego modules to evaluate alongside the built-in webhooks")
+	enforcementModes = flag.String("enforcement", "", "Comma-separated webhook=mode pairs, as for the server")
+	namespaceLabels  = flag.String("namespacelabels", "", "Comma-separated key=value labels of the request's namespace, for webhooks with a namespace selector. Namespace selectors are ignored if empty")
+)
+
+// result is one webhook's answer to the review
+type result struct {
+	Webhook  string   `json:"webhook"`
+	Verdict  string   `json:"verdict"`
+	Code     int32    `json:"code,omitempty"`
+	Message  string   `json:"message,omitempty"`
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// review is an AdmissionReview response of either version; the two only
+// differ in their apiVersion. Warnings are not in v1beta1.AdmissionResponse
+// in the Kubernetes version this is built against.
+type review struct {
+	Response *struct {
+		v1beta1.AdmissionResponse
+		Warnings []string `json:"warnings,omitempty"`
+	} `json:"response"`
+}
+
+func main() {
+	flag.Parse()
+	if err := run(os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "evaluate: %s\n", err.Error())
+		os.Exit(1)
+	}
+}
+
+func run(out io.Writer) error {
+	if *output != "table" && *output != "json" {
+		return fmt.Errorf("unknown output format %q, expected table or json", *output)
+	}
+	if *policyFile != "" {
+		p, err := policy.Load(*policyFile)
+		if err != nil {
+			return err
+		}
+		policy.Set(p)
+	}
+	if *celPolicyFile != "" {
+		if err := webhooks.RegisterCELPolicies(*celPolicyFile); err != nil {
+			return err
+		}
+	}
+	if *regoPolicyDir != "" {
+		if _, err := webhooks.RegisterRegoPolicy(*regoPolicyDir); err != nil {
+			return err
+		}
+	}
+	modes, err := enforcement.ParseModes(*enforcementModes)
+	if err != nil {
+		return err
+	}
+	for name, mode := range modes {
+		if _, ok := webhooks.Webhooks[name]; !ok {
+			return fmt.Errorf("no webhook named %s", name)
+		}
+		enforcement.SetMode(name, mode)
+	}
+
+	var lookup webhooks.NamespaceLabels
+	if *namespaceLabels != "" {
+		nsLabels, err := labels.ConvertSelectorToLabelsMap(*namespaceLabels)
+		if err != nil {
+			return fmt.Errorf("couldn't parse -namespacelabels: %s", err.Error())
+		}
+		lookup = func(string) (map[string]string, error) { return nsLabels, nil }
+	}
+
+	body, err := readInput(*inputFile)
+	if err != nil {
+		return err
+	}
+	results, err := evaluate(body, lookup)
+	if err != nil {
+		return err
+	}
+	if *output == "json" {
+		return writeJSON(out, results)
+	}
+	return writeTable(out, results)
+}
+
+func readInput(path string) ([]byte, error) {
+	if path == "-" {
+		return ioutil.ReadAll(os.Stdin)
+	}
+	return ioutil.ReadFile(path)
+}
+
+// evaluate sends body to every registered webhook which would receive it, in
+// name order, and collects their answers. namespaceLabels may be nil.
+func evaluate(body []byte, namespaceLabels webhooks.NamespaceLabels) ([]result, error) {
+	req, _, err := utils.ParseHTTPRequest(newHTTPRequest("/", body))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse AdmissionReview: %s", err.Error())
+	}
+
+	names := make([]string, 0, len(webhooks.Webhooks))
+	for name := range webhooks.Webhooks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	results := []result{}
+	for _, name := range names {
+		hook := webhooks.Webhooks[name]()
+		matched, err := webhooks.NewMatcher(hook).Matches(req, namespaceLabels)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't match %s: %s", name, err.Error())
+		}
+		if !matched {
+			continue
+		}
+		res, err := send(hook, body)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't evaluate %s: %s", name, err.Error())
+		}
+		results = append(results, res)
+	}
+	return results, nil
+}
+
+// send runs body through hook's handler, as the server would
+func send(hook webhooks.Webhook, body []byte) (result, error) {
+	recorder := httptest.NewRecorder()
+	hook.HandleRequest(recorder, newHTTPRequest(hook.GetURI(), body))
+
+	decoded := review{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &decoded); err != nil {
+		return result{}, err
+	}
+	if decoded.Response == nil {
+		return result{}, fmt.Errorf("no response in %s", recorder.Body.String())
+	}
+	resp := admissionctl.Response{AdmissionResponse: decoded.Response.AdmissionResponse}
+	ret := result{
+		Webhook:  hook.Name(),
+		Verdict:  metrics.Decision(resp),
+		Warnings: decoded.Response.Warnings,
+	}
+	if resp.Result != nil {
+		ret.Code = resp.Result.Code
+		ret.Message = resp.Result.Message
+		if ret.Message == "" {
+			ret.Message = string(resp.Result.Reason)
+		}
+	}
+	return ret, nil
+}
+
+func newHTTPRequest(uri string, body []byte) *http.Request {
+	r := httptest.NewRequest("POST", uri, bytes.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	return r
+}
+
+func writeJSON(out io.Writer, results []result) error {
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(results)
+}
+
+func writeTable(out io.Writer, results []result) error {
+	if len(results) == 0 {
+		_, err := fmt.Fprintln(out, "No webhooks match this request")
+		return err
+	}
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "WEBHOOK\tVERDICT\tCODE\tMESSAGE")
+	for _, res := range results {
+		message := res.Message
+		if len(res.Warnings) > 0 {
+			message = fmt.Sprintf("%s (warnings: %s)", message, strings.Join(res.Warnings, "; "))
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", res.Webhook, res.Verdict, res.Code, message)
+	}
+	return w.Flush()
+}
@@ -1,13 +1,120 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+
 	"github.com/lisa/k8s-webhook-framework/pkg/certinjector"
 )
 
+var (
+	certs      = flag.String("certs", string(certinjector.ServiceCA), "How the serving certificate is provisioned: service-ca, where the CA comes from the service-ca operator, or self-signed, where it is generated")
+	namespace  = flag.String("namespace", "", "Namespace of the webhook Service and of the Secret holding self-signed certificates")
+	secretName = flag.String("secretname", "webhook-cert", "Secret holding self-signed certificates")
+	service    = flag.String("service", "validation-webhook", "Service which self-signed certificates are issued for")
+	certDir    = flag.String("certdir", "/service-certs", "Directory where self-signed certificates are written for the webhook server. With -controller, they are renewed there as well, otherwise only when the injector next runs")
+
+	kubeconfig   = flag.String("kubeconfig", "", "Path of a kubeconfig to run against, for running outside the cluster")
+	annotation   = flag.String("annotation", certinjector.InjectFromAnnotation, "Annotation naming the CA sources of webhook configurations, which default to ConfigMaps")
+	configMapKey = flag.String("configmapkey", certinjector.ServiceCACertKey, "Key of the CA certificate in ConfigMap sources which don't name one")
+
+	controller = flag.Bool("controller", false, "Keep injecting CA bundles whenever they may have changed, instead of once. Replicas elect a leader with a Lease in -namespace")
+	resync     = flag.Duration("resync", 10*time.Minute, "How often the controller injects CA bundles even if nothing seems to have changed, and checks self-signed certificates")
+
+	log = logf.Log.WithName("injector")
+)
+
 func main() {
-	injector := certinjector.NewCertInjector()
-	err := injector.Inject()
+	flag.Parse()
+	logf.SetLogger(logf.ZapLogger(true))
+	provisioning, err := certinjector.ParseProvisioning(*certs)
+	exitOnError(err)
+	injector, err := certinjector.NewCertInjector(certinjector.Options{
+		Annotation:   *annotation,
+		ConfigMapKey: *configMapKey,
+		Kubeconfig:   *kubeconfig,
+		// Unless it keeps running as a controller, the injector exits as
+		// soon as it is done, before Events sent in the background would be
+		SyncEvents: !*controller,
+	})
+	exitOnError(err)
+	switch provisioning {
+	case certinjector.SelfSigned:
+		if *namespace == "" {
+			exitOnError(fmt.Errorf("-namespace is required for %s certificates", provisioning))
+		}
+		exitOnError(ensureSelfSigned(injector))
+	case certinjector.CertManager:
+		// cert-manager's cainjector takes care of the webhook configurations
+		return
+	}
+	if *controller {
+		runController(injector, provisioning)
+		return
+	}
+	exitOnError(injector.Inject())
+}
+
+// exitOnError exits non-zero with a summary of err, listing every failure
+// when there are several
+func exitOnError(err error) {
+	if err == nil {
+		return
+	}
+	if aggregate, ok := err.(utilerrors.Aggregate); ok && len(aggregate.Errors()) > 1 {
+		fmt.Fprintf(os.Stderr, "%d failures:\n", len(aggregate.Errors()))
+		for _, err := range aggregate.Errors() {
+			fmt.Fprintf(os.Stderr, "  %s\n", err.Error())
+		}
+	} else {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+	}
+	os.Exit(1)
+}
+
+// ensureSelfSigned renews the self-signed certificates if need be, and writes
+// them for the webhook server
+func ensureSelfSigned(injector *certinjector.CertInjector) error {
+	secret, err := injector.EnsureSelfSignedSecret(*namespace, *secretName, *service)
 	if err != nil {
-		panic(err)
+		return err
+	}
+	return certinjector.WriteCertificates(*certDir, secret)
+}
+
+// runController runs the injector's controller until SIGTERM
+func runController(injector *certinjector.CertInjector, provisioning certinjector.Provisioning) {
+	if *namespace == "" {
+		exitOnError(fmt.Errorf("-namespace is required for -controller"))
+	}
+	identity, err := os.Hostname()
+	exitOnError(err)
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	go func() {
+		<-signals
+		cancel()
+	}()
+	if provisioning == certinjector.SelfSigned {
+		// Every replica keeps the certificates its webhook server reads up to
+		// date, whether or not it leads, so that they pick up renewals
+		// without restarting
+		go wait.Until(func() {
+			if err := ensureSelfSigned(injector); err != nil {
+				log.Error(err, "Couldn't renew self-signed certificates")
+			}
+		}, *resync, ctx.Done())
 	}
+	c := certinjector.NewController(injector, *namespace, *resync)
+	exitOnError(c.RunWithLeaderElection(ctx, identity))
 }
@@ -1,17 +1,27 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
-	"crypto/x509"
 	"flag"
 	"fmt"
-	"io/ioutil"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
 
+	"github.com/lisa/k8s-webhook-framework/pkg/audit"
+	"github.com/lisa/k8s-webhook-framework/pkg/certwatcher"
+	"github.com/lisa/k8s-webhook-framework/pkg/enforcement"
+	"github.com/lisa/k8s-webhook-framework/pkg/health"
+	"github.com/lisa/k8s-webhook-framework/pkg/metrics"
+	"github.com/lisa/k8s-webhook-framework/pkg/policy"
 	"github.com/lisa/k8s-webhook-framework/pkg/webhooks"
+	"github.com/lisa/k8s-webhook-framework/pkg/webhooks/regopolicy"
 )
 
 var log = logf.Log.WithName("handler")
@@ -19,45 +29,211 @@ var log = logf.Log.WithName("handler")
 var (
 	listenAddress = flag.String("listen", "0.0.0.0", "listen address")
 	listenPort    = flag.String("port", "5000", "port to listen on")
+	metricsPath   = flag.String("metricspath", "/metrics", "URI on which to serve Prometheus metrics")
+	livenessPath  = flag.String("livenesspath", "/healthz", "URI on which to answer liveness probes")
+	readinessPath = flag.String("readinesspath", "/readyz", "URI on which to answer readiness probes")
+
+	drainPeriod = flag.Duration("drainperiod", 10*time.Second, "How long to keep serving after SIGTERM, while failing readiness, before shutting down")
+	idleTimeout = flag.Duration("idletimeout", 90*time.Second, "How long to keep idle keep-alive connections open")
 
 	useTLS  = flag.Bool("tls", false, "Use TLS? Must specify -tlskey, -tlscert, -cacert")
 	tlsKey  = flag.String("tlskey", "", "TLS Key for TLS")
 	tlsCert = flag.String("tlscert", "", "TLS Certificate")
 	caCert  = flag.String("cacert", "", "CA Cert file")
+
+	policyFile    = flag.String("policy", "", "Policy file naming privileged users, groups and namespaces, reloaded when it changes. Built-in defaults are used if empty")
+	celPolicyFile = flag.String("celpolicies", "", "File of CEL policies, each served as its own webhook. Only read at startup")
+	regoPolicyDir = flag.String("regopolicies", "", "Directory of Rego modules served as a webhook configured by its webhook.yaml. The modules are reloaded when they change")
+
+	auditLog           = flag.String("auditlog", "-", "Where to write the audit log of admission decisions: - for stdout, a file path, or empty to disable")
+	auditLogMaxSize    = flag.Int64("auditlogmaxsize", 100, "Size in megabytes at which the audit log file is rotated")
+	auditLogMaxBackups = flag.Int("auditlogmaxbackups", 5, "How many rotated audit log files to keep")
+	auditRedactExtra   = flag.String("auditredactextra", "", "Comma-separated keys of users' Extra to redact from the audit log, or * for all")
+
+	enforcementModes = flag.String("enforcement", "", "Comma-separated webhook=mode pairs setting what webhooks do with requests they would deny: enforce (the default), warn or audit")
+
+	misrouted = flag.String("misrouted", "log", "What to do with requests a webhook's rules don't match: log them, or reject them with an error")
 )
 
 func main() {
 	flag.Parse()
 	logf.SetLogger(logf.ZapLogger(true))
 	log.Info("HTTP server running at", "listen", fmt.Sprintf("%s:%s", *listenAddress, *listenPort))
+	if err := setupAuditLog(); err != nil {
+		log.Error(err, "Couldn't open audit log", "auditlog", *auditLog)
+		os.Exit(1)
+	}
+	if *celPolicyFile != "" {
+		if err := webhooks.RegisterCELPolicies(*celPolicyFile); err != nil {
+			log.Error(err, "Couldn't load CEL policies")
+			os.Exit(1)
+		}
+		log.Info("Loaded CEL policies", "path", *celPolicyFile)
+	}
+	var regoPolicy *regopolicy.RegoWebhook
+	if *regoPolicyDir != "" {
+		hook, err := webhooks.RegisterRegoPolicy(*regoPolicyDir)
+		if err != nil {
+			log.Error(err, "Couldn't load Rego policies")
+			os.Exit(1)
+		}
+		regoPolicy = hook
+		log.Info("Loaded Rego policies", "dir", *regoPolicyDir)
+	}
+	modes, err := enforcement.ParseModes(*enforcementModes)
+	if err != nil {
+		log.Error(err, "Couldn't parse enforcement modes")
+		os.Exit(1)
+	}
+	for name, mode := range modes {
+		if _, ok := webhooks.Webhooks[name]; !ok {
+			log.Error(fmt.Errorf("no webhook named %s", name), "Couldn't set enforcement mode")
+			os.Exit(1)
+		}
+		log.Info("Setting enforcement mode", "webhookName", name, "mode", mode)
+		enforcement.SetMode(name, mode)
+	}
+	misroutedAction, err := webhooks.ParseMisroutedAction(*misrouted)
+	if err != nil {
+		log.Error(err, "Couldn't parse -misrouted")
+		os.Exit(1)
+	}
+	readiness := health.NewReadiness(health.WebhooksCondition, health.CertificatesCondition)
+	http.HandleFunc(*livenessPath, health.Liveness)
+	http.Handle(*readinessPath, readiness)
+
 	seen := make(map[string]bool)
-	for name, hook := range webhooks.Webhooks {
-		if seen[hook().GetURI()] {
-			panic(fmt.Errorf("Duplicate webhook trying to lisen on %s", hook().GetURI()))
+	var hooksErr error
+	// The API server abandons a hook after its TimeoutSeconds, so there is no
+	// point in the server spending longer than the slowest hook's on a request.
+	var requestTimeout time.Duration
+	for name, hookFactory := range webhooks.Webhooks {
+		hook := hookFactory()
+		if hook == nil {
+			hooksErr = fmt.Errorf("webhook %s could not be constructed", name)
+			log.Error(hooksErr, "Skipping webhook", "webhookName", name)
+			continue
+		}
+		if seen[hook.GetURI()] {
+			panic(fmt.Errorf("Duplicate webhook trying to lisen on %s", hook.GetURI()))
+		}
+		seen[hook.GetURI()] = true
+		if timeout := time.Duration(hook.TimeoutSeconds()) * time.Second; timeout > requestTimeout {
+			requestTimeout = timeout
+		}
+		log.Info("Listening", "webhookName", name, "URI", hook.GetURI())
+		http.HandleFunc(hook.GetURI(), webhooks.CheckRoute(hook, misroutedAction))
+	}
+	for _, uri := range []string{*metricsPath, *livenessPath, *readinessPath} {
+		if seen[uri] {
+			panic(fmt.Errorf("Webhook trying to listen on the reserved URI %s", uri))
 		}
-		seen[name] = true
-		log.Info("Listening", "webhookName", name, "URI", hook().GetURI())
-		http.HandleFunc(hook().GetURI(), hook().HandleRequest)
 	}
+	if requestTimeout == 0 {
+		// No hooks; use the longest timeout the API server allows
+		requestTimeout = 30 * time.Second
+	}
+	readiness.Set(health.WebhooksCondition, hooksErr)
+	log.Info("Serving metrics", "URI", *metricsPath)
+	http.Handle(*metricsPath, metrics.Handler())
 
 	server := &http.Server{
-		Addr: fmt.Sprintf("%s:%s", *listenAddress, *listenPort),
+		Addr:         fmt.Sprintf("%s:%s", *listenAddress, *listenPort),
+		ReadTimeout:  requestTimeout,
+		WriteTimeout: requestTimeout,
+		IdleTimeout:  *idleTimeout,
+	}
+	stop := make(chan struct{})
+	serveErr := make(chan error, 1)
+	if *policyFile != "" {
+		watcher, err := policy.NewWatcher(*policyFile)
+		if err != nil {
+			log.Error(err, "Couldn't load policy")
+			os.Exit(1)
+		}
+		go func() {
+			if err := watcher.Start(stop); err != nil {
+				log.Error(err, "Couldn't watch policy for changes")
+			}
+		}()
+		log.Info("Loaded policy", "path", *policyFile)
+	}
+	if regoPolicy != nil {
+		go func() {
+			if err := regoPolicy.Start(stop); err != nil {
+				log.Error(err, "Couldn't watch Rego policies for changes")
+			}
+		}()
 	}
 	if *useTLS {
-		cafile, err := ioutil.ReadFile(*caCert)
+		watcher, err := certwatcher.New(*tlsCert, *tlsKey, *caCert)
 		if err != nil {
-			log.Error(err, "Couldn't read CA cert file")
+			log.Error(err, "Couldn't load TLS certificates")
 			os.Exit(1)
 		}
-		certpool := x509.NewCertPool()
-		certpool.AppendCertsFromPEM(cafile)
+		go func() {
+			if err := watcher.Start(stop); err != nil {
+				log.Error(err, "Couldn't watch TLS certificates for rotation")
+			}
+		}()
 
+		// The CA bundle is only watched so its rotations are logged and counted:
+		// RootCAs has no bearing on a server which never dials out.
 		server.TLSConfig = &tls.Config{
-			RootCAs: certpool,
+			GetCertificate: watcher.GetCertificate,
 		}
-		log.Error(server.ListenAndServeTLS(*tlsCert, *tlsKey), "Error serving TLS")
+		readiness.Set(health.CertificatesCondition, nil)
+		// The key pair comes from GetCertificate
+		go func() { serveErr <- server.ListenAndServeTLS("", "") }()
 	} else {
-		log.Error(server.ListenAndServe(), "Error serving non-TLS connection")
+		// Nothing to load
+		readiness.Set(health.CertificatesCondition, nil)
+		go func() { serveErr <- server.ListenAndServe() }()
 	}
 
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	select {
+	case err := <-serveErr:
+		log.Error(err, "Error serving")
+		os.Exit(1)
+	case sig := <-signals:
+		log.Info("Received signal, draining", "signal", sig.String(), "drainPeriod", drainPeriod.String())
+	}
+
+	// Fail readiness first so no new requests are routed here, but keep
+	// serving whatever still arrives while that propagates.
+	readiness.Set(health.ShutdownCondition, fmt.Errorf("server is shutting down"))
+	time.Sleep(*drainPeriod)
+
+	// Give in-flight requests as long as the API server would wait for them
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Error(err, "Error waiting for in-flight requests to finish")
+	}
+	close(stop)
+	log.Info("Shut down")
+}
+
+// setupAuditLog points the audit log at the sink chosen with -auditlog
+func setupAuditLog() error {
+	var redact []string
+	if *auditRedactExtra != "" {
+		redact = strings.Split(*auditRedactExtra, ",")
+	}
+	switch *auditLog {
+	case "":
+		return nil
+	case "-":
+		audit.SetLogger(audit.NewLogger(os.Stdout, redact))
+	default:
+		f, err := audit.OpenRotatingFile(*auditLog, *auditLogMaxSize*1024*1024, *auditLogMaxBackups)
+		if err != nil {
+			return err
+		}
+		audit.SetLogger(audit.NewLogger(f, redact))
+	}
+	log.Info("Writing audit log", "auditlog", *auditLog)
+	return nil
 }
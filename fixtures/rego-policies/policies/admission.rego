@@ -0,0 +1,28 @@
+# Requests are denied with every message in deny. A package may also define
+# allow, in which case requests are denied unless it is true.
+package admission
+
+sre_groups := {"osd-sre-admins", "osd-sre-cluster-admins"}
+
+is_sre {
+	sre_groups[input.request.userInfo.groups[_]]
+}
+
+# Only SREs may run privileged Pods outside of openshift- namespaces
+restricted {
+	not is_sre
+	not startswith(input.request.namespace, "openshift-")
+}
+
+deny[msg] {
+	restricted
+	container := input.request.object.spec.containers[_]
+	container.securityContext.privileged
+	msg := sprintf("Container %s may not be privileged", [container.name])
+}
+
+deny[msg] {
+	restricted
+	input.request.object.spec.hostNetwork
+	msg := "Pods may not use the host network"
+}
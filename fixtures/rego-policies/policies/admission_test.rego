@@ -0,0 +1,29 @@
+package admission
+
+pod(namespace, groups, spec) = {"request": {
+	"namespace": namespace,
+	"userInfo": {"username": "test-user", "groups": groups},
+	"object": {"spec": spec},
+}}
+
+privileged := {"containers": [{"name": "app", "securityContext": {"privileged": true}}]}
+
+test_privileged_denied {
+	deny["Container app may not be privileged"] with input as pod("my-project", ["dedicated-admins"], privileged)
+}
+
+test_host_network_denied {
+	deny["Pods may not use the host network"] with input as pod("my-project", [], {"containers": [], "hostNetwork": true})
+}
+
+test_sre_allowed {
+	count(deny) == 0 with input as pod("my-project", ["osd-sre-admins"], privileged)
+}
+
+test_openshift_namespace_allowed {
+	count(deny) == 0 with input as pod("openshift-monitoring", [], privileged)
+}
+
+test_unprivileged_allowed {
+	count(deny) == 0 with input as pod("my-project", [], {"containers": [{"name": "app"}]})
+}
@@ -3,9 +3,13 @@ module github.com/lisa/k8s-webhook-framework
 go 1.14
 
 require (
+	github.com/fsnotify/fsnotify v1.4.7
 	github.com/ghodss/yaml v1.0.1-0.20190212211648-25d852aebe32
+	github.com/google/cel-go v0.12.6
+	github.com/open-policy-agent/opa v0.23.2
 	github.com/openshift/api v3.9.1-0.20191111211345-a27ff30ebf09+incompatible
 	github.com/openshift/hive v1.0.4
+	github.com/prometheus/client_golang v1.2.1
 	k8s.io/api v0.18.2
 	k8s.io/apimachinery v0.18.2
 	k8s.io/client-go v12.0.0+incompatible
@@ -0,0 +1,198 @@
+package audit
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+	admissionctl "sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	"github.com/lisa/k8s-webhook-framework/pkg/enforcement"
+	"github.com/lisa/k8s-webhook-framework/pkg/metrics"
+)
+
+const (
+	// RuleAnnotation is the AuditAnnotations key under which hooks name the
+	// rule that decided a request. The API server copies it into its own
+	// audit events as <webhook>/rule.
+	RuleAnnotation string = "rule"
+	// RedactAll redacts every key of the users' Extra
+	RedactAll string = "*"
+
+	redacted string = "REDACTED"
+)
+
+var log = logf.Log.WithName("audit")
+
+// Event is one admission decision, as written to the audit log.
+type Event struct {
+	Time        time.Time                              `json:"time"`
+	Webhook     string                                 `json:"webhook"`
+	UID         types.UID                              `json:"uid"`
+	User        string                                 `json:"user"`
+	Groups      []string                               `json:"groups,omitempty"`
+	Extra       map[string]authenticationv1.ExtraValue `json:"extra,omitempty"`
+	Operation   string                                 `json:"operation"`
+	Resource    metav1.GroupVersionResource            `json:"resource"`
+	SubResource string                                 `json:"subResource,omitempty"`
+	Name        string                                 `json:"name,omitempty"`
+	Namespace   string                                 `json:"namespace,omitempty"`
+	Decision    string                                 `json:"decision"`
+	Reason      string                                 `json:"reason,omitempty"`
+	Rule        string                                 `json:"rule,omitempty"`
+	// Enforcement is the webhook's enforcement mode when a request was
+	// allowed despite its decision
+	Enforcement string `json:"enforcement,omitempty"`
+}
+
+// objectMeta is the fragment of an object needed when the API server hasn't
+// filled in the request's name and namespace, eg for generateName
+type objectMeta struct {
+	Metadata struct {
+		Name      string `json:"name"`
+		Namespace string `json:"namespace"`
+	} `json:"metadata"`
+}
+
+// Logger writes an Event for each admission decision to a sink, one JSON
+// object per line. It is safe for concurrent use.
+type Logger struct {
+	mu        sync.Mutex
+	w         io.Writer
+	redact    map[string]bool
+	redactAll bool
+}
+
+// NewLogger creates a Logger writing to w which redacts the values of the
+// users' Extra keys listed in redactExtra. RedactAll redacts them all.
+func NewLogger(w io.Writer, redactExtra []string) *Logger {
+	l := &Logger{
+		w:      w,
+		redact: make(map[string]bool),
+	}
+	for _, key := range redactExtra {
+		if key == RedactAll {
+			l.redactAll = true
+		}
+		l.redact[key] = true
+	}
+	return l
+}
+
+// Log writes an Event of hookName answering req with resp.
+func (l *Logger) Log(hookName string, req admissionctl.Request, resp admissionctl.Response) error {
+	event := l.newEvent(hookName, req, resp)
+	b, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	// One Write per event, so that a rotation never splits one
+	b = append(b, '\n')
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err = l.w.Write(b)
+	return err
+}
+
+func (l *Logger) newEvent(hookName string, req admissionctl.Request, resp admissionctl.Response) Event {
+	event := Event{
+		Time:        time.Now().UTC(),
+		Webhook:     hookName,
+		UID:         req.UID,
+		User:        req.UserInfo.Username,
+		Groups:      req.UserInfo.Groups,
+		Extra:       l.redactExtra(req.UserInfo.Extra),
+		Operation:   string(req.Operation),
+		Resource:    req.Resource,
+		SubResource: req.SubResource,
+		Name:        req.Name,
+		Namespace:   req.Namespace,
+		Decision:    metrics.Decision(resp),
+		Rule:        resp.AuditAnnotations[RuleAnnotation],
+		Enforcement: resp.AuditAnnotations[enforcement.ModeAnnotation],
+	}
+	if resp.Result != nil {
+		event.Reason = resp.Result.Message
+		if event.Reason == "" {
+			event.Reason = string(resp.Result.Reason)
+		}
+	}
+	if event.Name == "" || event.Namespace == "" {
+		raw := req.Object.Raw
+		if len(raw) == 0 {
+			raw = req.OldObject.Raw
+		}
+		meta := objectMeta{}
+		// Best effort; the name and namespace are left empty otherwise
+		if err := json.Unmarshal(raw, &meta); err == nil {
+			if event.Name == "" {
+				event.Name = meta.Metadata.Name
+			}
+			if event.Namespace == "" {
+				event.Namespace = meta.Metadata.Namespace
+			}
+		}
+	}
+	return event
+}
+
+func (l *Logger) redactExtra(extra map[string]authenticationv1.ExtraValue) map[string]authenticationv1.ExtraValue {
+	if len(extra) == 0 {
+		return nil
+	}
+	ret := make(map[string]authenticationv1.ExtraValue, len(extra))
+	for key, value := range extra {
+		if l.redactAll || l.redact[key] {
+			ret[key] = authenticationv1.ExtraValue{redacted}
+			continue
+		}
+		ret[key] = value
+	}
+	return ret
+}
+
+// loggerHolder lets a nil *Logger be stored in an atomic.Value
+type loggerHolder struct {
+	l *Logger
+}
+
+var logger atomic.Value
+
+func init() {
+	logger.Store(loggerHolder{})
+}
+
+// SetLogger sets the Logger used by Record. A nil Logger disables the audit
+// log, which is the default.
+func SetLogger(l *Logger) {
+	logger.Store(loggerHolder{l: l})
+}
+
+// Record writes an Event of hookName answering req with resp to the Logger
+// set with SetLogger, if any. Failures to write are logged, but otherwise
+// don't affect the response.
+func Record(hookName string, req admissionctl.Request, resp admissionctl.Response) {
+	l := logger.Load().(loggerHolder).l
+	if l == nil {
+		return
+	}
+	if err := l.Log(hookName, req, resp); err != nil {
+		log.Error(err, "Couldn't write audit record", "webhookName", hookName, "uid", req.UID)
+	}
+}
+
+// WithRule names the rule which decided resp, for the audit log. See
+// RuleAnnotation.
+func WithRule(resp admissionctl.Response, rule string) admissionctl.Response {
+	if resp.AuditAnnotations == nil {
+		resp.AuditAnnotations = make(map[string]string)
+	}
+	resp.AuditAnnotations[RuleAnnotation] = rule
+	return resp
+}
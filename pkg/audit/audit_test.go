@@ -0,0 +1,163 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/lisa/k8s-webhook-framework/pkg/metrics"
+
+	"k8s.io/api/admission/v1beta1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	admissionctl "sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func createRequest(obj string) admissionctl.Request {
+	return admissionctl.Request{
+		AdmissionRequest: v1beta1.AdmissionRequest{
+			UID:       "abcd-123",
+			Operation: v1beta1.Create,
+			Resource: metav1.GroupVersionResource{
+				Group:    "operators.coreos.com",
+				Version:  "v1alpha1",
+				Resource: "subscriptions",
+			},
+			Namespace: "redhat-namespace",
+			UserInfo: authenticationv1.UserInfo{
+				Username: "test-user",
+				Groups:   []string{"dedicated-admins", "system:authenticated"},
+				Extra: map[string]authenticationv1.ExtraValue{
+					"scopes.authorization.openshift.io": {"user:full"},
+					"token.example.com":                 {"secret"},
+				},
+			},
+			Object: runtime.RawExtension{Raw: []byte(obj)},
+		},
+	}
+}
+
+func logEvent(t *testing.T, l *Logger, buf *bytes.Buffer, req admissionctl.Request, resp admissionctl.Response) Event {
+	if err := l.Log("subscription-validation", req, resp); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("Expected one line per event, got %d: %s", len(lines), buf.String())
+	}
+	event := Event{}
+	if err := json.Unmarshal([]byte(lines[0]), &event); err != nil {
+		t.Fatalf("Couldn't parse audit event %s: %s", lines[0], err.Error())
+	}
+	return event
+}
+
+func TestLog(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := NewLogger(buf, []string{"token.example.com"})
+	req := createRequest(`{"metadata": {"name": "mysub"}}`)
+	resp := WithRule(admissionctl.Denied("Dedicated admins may not create Subscriptions"), "privileged-namespace")
+
+	event := logEvent(t, l, buf, req, resp)
+	if event.UID != "abcd-123" || event.User != "test-user" || event.Operation != "CREATE" {
+		t.Fatalf("Expected the request's UID, user and operation, got %+v", event)
+	}
+	if !reflect.DeepEqual(event.Groups, req.UserInfo.Groups) {
+		t.Fatalf("Expected groups %v, got %v", req.UserInfo.Groups, event.Groups)
+	}
+	if event.Resource != req.Resource {
+		t.Fatalf("Expected resource %v, got %v", req.Resource, event.Resource)
+	}
+	// The name only comes from the object, the namespace from the request
+	if event.Name != "mysub" || event.Namespace != "redhat-namespace" {
+		t.Fatalf("Expected mysub in redhat-namespace, got %s in %s", event.Name, event.Namespace)
+	}
+	if event.Decision != metrics.DecisionDenied || event.Reason != "Dedicated admins may not create Subscriptions" || event.Rule != "privileged-namespace" {
+		t.Fatalf("Expected the denial and its rule, got %+v", event)
+	}
+	expectedExtra := map[string]authenticationv1.ExtraValue{
+		"scopes.authorization.openshift.io": {"user:full"},
+		"token.example.com":                 {redacted},
+	}
+	if !reflect.DeepEqual(event.Extra, expectedExtra) {
+		t.Fatalf("Expected extra %v, got %v", expectedExtra, event.Extra)
+	}
+}
+
+func TestLogErrored(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := NewLogger(buf, []string{RedactAll})
+	resp := admissionctl.Errored(http.StatusBadRequest, fmt.Errorf("Could not parse Subscription"))
+
+	event := logEvent(t, l, buf, createRequest(`not json`), resp)
+	if event.Decision != metrics.DecisionErrored || event.Reason != "Could not parse Subscription" || event.Rule != "" {
+		t.Fatalf("Expected an error without a rule, got %+v", event)
+	}
+	if event.Name != "" {
+		t.Fatalf("Expected no name from an unparseable object, got %s", event.Name)
+	}
+	for key, value := range event.Extra {
+		if !reflect.DeepEqual(value, authenticationv1.ExtraValue{redacted}) {
+			t.Fatalf("Expected %s to be redacted, got %v", key, value)
+		}
+	}
+}
+
+func TestRecord(t *testing.T) {
+	buf := &bytes.Buffer{}
+	// Disabled by default
+	Record("test-hook", createRequest(`{}`), admissionctl.Allowed(""))
+
+	SetLogger(NewLogger(buf, nil))
+	defer SetLogger(nil)
+	Record("test-hook", createRequest(`{}`), admissionctl.Allowed(""))
+	if strings.Count(buf.String(), "\n") != 1 {
+		t.Fatalf("Expected one audit event, got %q", buf.String())
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatalf("Couldn't create temporary directory: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+
+	r, err := OpenRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	defer r.Close()
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := r.Write([]byte(line)); err != nil {
+			t.Fatalf("Unexpected error: %s", err.Error())
+		}
+	}
+
+	expected := map[string]string{
+		path:        "fourth\n",
+		path + ".1": "third\n",
+		path + ".2": "second\n",
+	}
+	for file, contents := range expected {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatalf("Couldn't read %s: %s", file, err.Error())
+		}
+		if string(b) != contents {
+			t.Fatalf("Expected %s to contain %q, got %q", file, contents, string(b))
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Fatalf("Expected only 2 backups to be kept")
+	}
+}
@@ -0,0 +1,104 @@
+package audit
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// RotatingFile is an io.Writer appending to a file, which is rotated once it
+// grows past a size: path is renamed to path.1, path.1 to path.2 and so on,
+// keeping at most MaxBackups old files. It is safe for concurrent use.
+type RotatingFile struct {
+	mu         sync.Mutex
+	path       string
+	maxBytes   int64
+	maxBackups int
+
+	f    *os.File
+	size int64
+}
+
+// OpenRotatingFile opens path for appending, creating it if need be. The file
+// is rotated before a write would take it past maxBytes; maxBytes <= 0
+// disables rotation.
+func OpenRotatingFile(path string, maxBytes int64, maxBackups int) (*RotatingFile, error) {
+	r := &RotatingFile{
+		path:       path,
+		maxBytes:   maxBytes,
+		maxBackups: maxBackups,
+	}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *RotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f = f
+	r.size = info.Size()
+	return nil
+}
+
+// Write appends p to the file, rotating it first if p would take it past its
+// maximum size. p is never split between two files.
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.maxBytes > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxBytes {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// rotate moves the current file out of the way and opens a new one. The file
+// is reopened even if that fails, so that later writes still have somewhere to
+// go.
+func (r *RotatingFile) rotate() error {
+	if err := r.f.Close(); err != nil {
+		return err
+	}
+	err := r.shift()
+	if openErr := r.open(); openErr != nil {
+		return openErr
+	}
+	return err
+}
+
+// shift renames path to path.1, path.1 to path.2 and so on
+func (r *RotatingFile) shift() error {
+	if r.maxBackups <= 0 {
+		return os.Remove(r.path)
+	}
+	for i := r.maxBackups - 1; i > 0; i-- {
+		err := os.Rename(r.backup(i), r.backup(i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.Rename(r.path, r.backup(1))
+}
+
+func (r *RotatingFile) backup(i int) string {
+	return fmt.Sprintf("%s.%d", r.path, i)
+}
+
+// Close closes the file.
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.f.Close()
+}
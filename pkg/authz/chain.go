@@ -0,0 +1,73 @@
+package authz
+
+import (
+	authenticationv1 "k8s.io/api/authentication/v1"
+	admissionctl "sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+const (
+	// NoMatchRule is the rule recorded when no rule of a Chain matches
+	NoMatchRule string = "no-matching-rule"
+	// noMatchReason is the reason requests no rule matches are denied with
+	noMatchReason string = "No authorization rule matched"
+)
+
+// Rule allows or denies the requests of users its predicate matches
+type Rule struct {
+	// Name is recorded in the audit log when the rule decides a request
+	Name string
+	// When selects the users the rule applies to
+	When Predicate
+	// Allow the request, rather than deny it
+	Allow bool
+	// Reason is the message of the response
+	Reason string
+}
+
+// Allow returns the rule name, which allows the requests of the users when
+// matches with reason
+func Allow(name string, when Predicate, reason string) Rule {
+	return Rule{Name: name, When: when, Allow: true, Reason: reason}
+}
+
+// Deny returns the rule name, which denies the requests of the users when
+// matches with reason
+func Deny(name string, when Predicate, reason string) Rule {
+	return Rule{Name: name, When: when, Allow: false, Reason: reason}
+}
+
+// Decision is the outcome of evaluating a Chain
+type Decision struct {
+	Allowed bool
+	// Rule names the rule which decided, or is NoMatchRule
+	Rule   string
+	Reason string
+}
+
+// Chain is an ordered list of rules, the first of which matching a user
+// decides. Users no rule matches are denied, so chains should end with a
+// rule for Always.
+type Chain []Rule
+
+// Evaluate decides whether user may make the request c guards
+func (c Chain) Evaluate(user authenticationv1.UserInfo) Decision {
+	for _, rule := range c {
+		if rule.When(user) {
+			return Decision{Allowed: rule.Allow, Rule: rule.Name, Reason: rule.Reason}
+		}
+	}
+	return Decision{Allowed: false, Rule: NoMatchRule, Reason: noMatchReason}
+}
+
+// Response turns d into the response to request. The hooks record d.Rule in
+// the audit log themselves, with audit.WithRule.
+func (d Decision) Response(request admissionctl.Request) admissionctl.Response {
+	var ret admissionctl.Response
+	if d.Allowed {
+		ret = admissionctl.Allowed(d.Reason)
+	} else {
+		ret = admissionctl.Denied(d.Reason)
+	}
+	ret.UID = request.AdmissionRequest.UID
+	return ret
+}
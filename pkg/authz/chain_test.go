@@ -0,0 +1,71 @@
+package authz
+
+import (
+	"net/http"
+	"testing"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"k8s.io/apimachinery/pkg/types"
+	admissionctl "sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func TestChain(t *testing.T) {
+	chain := Chain{
+		Allow("cluster-admin", UserIn("kube:admin"), "Cluster admins may access"),
+		Deny("dedicated-admin", InGroup("dedicated-admins"), "Dedicated admins may not access"),
+		Allow("rbac", Always(), "RBAC allowed"),
+	}
+	tests := []struct {
+		user     authenticationv1.UserInfo
+		expected Decision
+	}{
+		{
+			// The first matching rule decides
+			user:     authenticationv1.UserInfo{Username: "kube:admin", Groups: []string{"dedicated-admins"}},
+			expected: Decision{Allowed: true, Rule: "cluster-admin", Reason: "Cluster admins may access"},
+		},
+		{
+			user:     authenticationv1.UserInfo{Username: "test-user", Groups: []string{"dedicated-admins"}},
+			expected: Decision{Allowed: false, Rule: "dedicated-admin", Reason: "Dedicated admins may not access"},
+		},
+		{
+			user:     authenticationv1.UserInfo{Username: "test-user"},
+			expected: Decision{Allowed: true, Rule: "rbac", Reason: "RBAC allowed"},
+		},
+	}
+	for _, test := range tests {
+		if got := chain.Evaluate(test.user); got != test.expected {
+			t.Errorf("Expected %+v for %+v, got %+v", test.expected, test.user, got)
+		}
+	}
+
+	// Users no rule matches are denied
+	got := chain[:2].Evaluate(authenticationv1.UserInfo{Username: "test-user"})
+	if got.Allowed || got.Rule != NoMatchRule {
+		t.Errorf("Expected users no rule matches to be denied, got %+v", got)
+	}
+}
+
+func TestResponse(t *testing.T) {
+	request := admissionctl.Request{}
+	request.UID = types.UID("abcd-123")
+
+	for _, test := range []struct {
+		decision     Decision
+		expectedCode int32
+	}{
+		{decision: Decision{Allowed: true, Rule: "rbac", Reason: "RBAC allowed"}, expectedCode: http.StatusOK},
+		{decision: Decision{Allowed: false, Rule: "default-deny", Reason: "Denied"}, expectedCode: http.StatusForbidden},
+	} {
+		resp := test.decision.Response(request)
+		if resp.UID != request.UID {
+			t.Fatalf("Expected the response to carry the request's UID, got %q", resp.UID)
+		}
+		if resp.Result == nil || resp.Result.Code != test.expectedCode {
+			t.Fatalf("Expected a %d response, got %+v", test.expectedCode, resp.Result)
+		}
+		if string(resp.Result.Reason) != test.decision.Reason {
+			t.Fatalf("Expected the decision's reason %q, got %q", test.decision.Reason, resp.Result.Reason)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+// Package authz decides which users may make a request, so that the hooks
+// share one definition of who is an admin, a privileged service account and
+// so on. Predicates match users and are composed into a Chain of rules, the
+// first matching of which allows or denies the request.
+package authz
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/lisa/k8s-webhook-framework/pkg/webhooks/utils"
+	authenticationv1 "k8s.io/api/authentication/v1"
+)
+
+const (
+	// serviceAccountUserPrefix starts the usernames of service accounts,
+	// system:serviceaccount:<namespace>:<name>
+	serviceAccountUserPrefix string = "system:serviceaccount:"
+	// serviceAccountGroupPrefix starts the group of each service account's
+	// namespace, system:serviceaccounts:<namespace>
+	serviceAccountGroupPrefix string = "system:serviceaccounts:"
+)
+
+// Predicate tells whether the user making a request matches
+type Predicate func(user authenticationv1.UserInfo) bool
+
+// Always matches every user, eg for the last rule of a Chain
+func Always() Predicate {
+	return If(true)
+}
+
+// If matches every user when cond holds and none otherwise. It brings facts
+// about the request, such as the kind of object it is for, into a rule.
+func If(cond bool) Predicate {
+	return func(authenticationv1.UserInfo) bool { return cond }
+}
+
+// UserIn matches users named one of users
+func UserIn(users ...string) Predicate {
+	return func(user authenticationv1.UserInfo) bool {
+		return utils.SliceContains(user.Username, users)
+	}
+}
+
+// UserMatches matches users whose name matches re
+func UserMatches(re *regexp.Regexp) Predicate {
+	return func(user authenticationv1.UserInfo) bool {
+		return re.MatchString(user.Username)
+	}
+}
+
+// InGroup matches users in at least one of groups
+func InGroup(groups ...string) Predicate {
+	return func(user authenticationv1.UserInfo) bool {
+		for _, group := range user.Groups {
+			if utils.SliceContains(group, groups) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// GroupMatches matches users in at least one group whose name matches re
+func GroupMatches(re *regexp.Regexp) Predicate {
+	return func(user authenticationv1.UserInfo) bool {
+		for _, group := range user.Groups {
+			if re.MatchString(group) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// ServiceAccountIn matches service accounts whose namespace matches re. They
+// are recognised by their username or, as the API server adds it for them,
+// the group of their namespace.
+func ServiceAccountIn(re *regexp.Regexp) Predicate {
+	return func(user authenticationv1.UserInfo) bool {
+		if strings.HasPrefix(user.Username, serviceAccountUserPrefix) {
+			parts := strings.Split(strings.TrimPrefix(user.Username, serviceAccountUserPrefix), ":")
+			if len(parts) == 2 && re.MatchString(parts[0]) {
+				return true
+			}
+		}
+		for _, group := range user.Groups {
+			if strings.HasPrefix(group, serviceAccountGroupPrefix) && re.MatchString(strings.TrimPrefix(group, serviceAccountGroupPrefix)) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// HasExtra matches users with value among the Extra of key, eg the scopes
+// of an OAuth token
+func HasExtra(key, value string) Predicate {
+	return func(user authenticationv1.UserInfo) bool {
+		return utils.SliceContains(value, user.Extra[key])
+	}
+}
+
+// All matches users which every one of predicates matches
+func All(predicates ...Predicate) Predicate {
+	return func(user authenticationv1.UserInfo) bool {
+		for _, p := range predicates {
+			if !p(user) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// Any matches users which at least one of predicates matches
+func Any(predicates ...Predicate) Predicate {
+	return func(user authenticationv1.UserInfo) bool {
+		for _, p := range predicates {
+			if p(user) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Not matches users which p doesn't
+func Not(p Predicate) Predicate {
+	return func(user authenticationv1.UserInfo) bool {
+		return !p(user)
+	}
+}
@@ -0,0 +1,63 @@
+package authz
+
+import (
+	"regexp"
+	"testing"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+)
+
+func TestPredicates(t *testing.T) {
+	sre := authenticationv1.UserInfo{
+		Username: "sre-user",
+		Groups:   []string{"system:authenticated", "osd-sre-admins"},
+		Extra: map[string]authenticationv1.ExtraValue{
+			"scopes.authorization.openshift.io": {"user:full"},
+		},
+	}
+	sa := authenticationv1.UserInfo{
+		Username: "system:serviceaccount:openshift-monitoring:prometheus",
+	}
+	saByGroup := authenticationv1.UserInfo{
+		Username: "prometheus",
+		Groups:   []string{"system:serviceaccounts", "system:serviceaccounts:openshift-monitoring"},
+	}
+	customer := authenticationv1.UserInfo{
+		Username: "test-user",
+		Groups:   []string{"system:authenticated", "dedicated-admins"},
+	}
+	openshift := regexp.MustCompile(`^openshift-.*`)
+
+	tests := []struct {
+		name      string
+		predicate Predicate
+		user      authenticationv1.UserInfo
+		expected  bool
+	}{
+		{name: "always", predicate: Always(), user: customer, expected: true},
+		{name: "if false", predicate: If(false), user: sre, expected: false},
+		{name: "user in", predicate: UserIn("kube:admin", "sre-user"), user: sre, expected: true},
+		{name: "user not in", predicate: UserIn("kube:admin", "sre-user"), user: customer, expected: false},
+		{name: "user matches", predicate: UserMatches(regexp.MustCompile(`^test-`)), user: customer, expected: true},
+		{name: "in group", predicate: InGroup("osd-sre-admins", "osd-sre-cluster-admins"), user: sre, expected: true},
+		{name: "not in group", predicate: InGroup("osd-sre-admins", "osd-sre-cluster-admins"), user: customer, expected: false},
+		{name: "group matches", predicate: GroupMatches(regexp.MustCompile(`^osd-sre.*`)), user: sre, expected: true},
+		{name: "group doesn't match", predicate: GroupMatches(regexp.MustCompile(`^osd-sre.*`)), user: customer, expected: false},
+		{name: "service account by name", predicate: ServiceAccountIn(openshift), user: sa, expected: true},
+		{name: "service account by group", predicate: ServiceAccountIn(openshift), user: saByGroup, expected: true},
+		{name: "service account elsewhere", predicate: ServiceAccountIn(regexp.MustCompile(`^kube-.*`)), user: sa, expected: false},
+		{name: "not a service account", predicate: ServiceAccountIn(regexp.MustCompile(`.*`)), user: customer, expected: false},
+		{name: "has extra", predicate: HasExtra("scopes.authorization.openshift.io", "user:full"), user: sre, expected: true},
+		{name: "missing extra", predicate: HasExtra("scopes.authorization.openshift.io", "user:full"), user: customer, expected: false},
+		{name: "all", predicate: All(InGroup("dedicated-admins"), UserIn("test-user")), user: customer, expected: true},
+		{name: "not all", predicate: All(InGroup("dedicated-admins"), UserIn("kube:admin")), user: customer, expected: false},
+		{name: "any", predicate: Any(UserIn("kube:admin"), InGroup("dedicated-admins")), user: customer, expected: true},
+		{name: "none", predicate: Any(), user: customer, expected: false},
+		{name: "not", predicate: Not(InGroup("dedicated-admins")), user: sre, expected: true},
+	}
+	for _, test := range tests {
+		if got := test.predicate(test.user); got != test.expected {
+			t.Errorf("%s: expected %t, got %t for %+v", test.name, test.expected, got, test.user)
+		}
+	}
+}
@@ -0,0 +1,295 @@
+package certinjector
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	admissionregv1 "k8s.io/api/admissionregistration/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+)
+
+const (
+	// LeaseName is the Lease replicas of the controller elect a leader with
+	LeaseName string = "webhook-cert-injector"
+
+	// retryPeriod is how long to wait before injecting again after a failure
+	retryPeriod = 10 * time.Second
+)
+
+var log = logf.Log.WithName("certinjector")
+
+// Controller keeps the CA bundles of annotated webhook configurations up to
+// date: it injects them again whenever an annotated configuration changes,
+// whenever one of the ConfigMaps or Secrets their annotations name as CA
+// sources changes, in whichever namespace, and every resync period.
+type Controller struct {
+	injector  *CertInjector
+	namespace string
+	resync    time.Duration
+
+	// queue holds at most one pending injection, as each one covers every
+	// configuration
+	queue chan struct{}
+
+	// validating and mutating are the informers of the webhook
+	// configurations, which name the sources
+	validating cache.SharedIndexInformer
+	mutating   cache.SharedIndexInformer
+
+	mu sync.Mutex
+	// sources are the ConfigMaps and Secrets named by annotations
+	sources map[caSourceRef]bool
+	// watches stop the ConfigMap and Secret informers of each namespace with
+	// sources when closed
+	watches map[string]chan struct{}
+}
+
+// caSourceRef is the object a caSource reads from, whichever its key
+type caSourceRef struct {
+	kind      sourceKind
+	namespace string
+	name      string
+}
+
+// NewController returns a Controller for injector, holding its leader
+// election Lease in namespace.
+func NewController(injector *CertInjector, namespace string, resync time.Duration) *Controller {
+	return &Controller{
+		injector:  injector,
+		namespace: namespace,
+		resync:    resync,
+		queue:     make(chan struct{}, 1),
+		sources:   make(map[caSourceRef]bool),
+		watches:   make(map[string]chan struct{}),
+	}
+}
+
+func (c *Controller) enqueue() {
+	select {
+	case c.queue <- struct{}{}:
+	default:
+		// Already pending
+	}
+}
+
+// handler enqueues an injection for every event on objects accepted by filter
+func (c *Controller) handler(filter func(obj interface{}) bool) cache.ResourceEventHandler {
+	return cache.FilteringResourceEventHandler{
+		FilterFunc: filter,
+		Handler: cache.ResourceEventHandlerFuncs{
+			AddFunc:    func(interface{}) { c.enqueue() },
+			UpdateFunc: func(interface{}, interface{}) { c.enqueue() },
+			DeleteFunc: func(interface{}) { c.enqueue() },
+		},
+	}
+}
+
+// isAnnotated accepts webhook configurations whose CA bundle is injected
+func (c *Controller) isAnnotated(obj interface{}) bool {
+	keys := c.injector.annotationKeys()
+	switch hook := obj.(type) {
+	case *admissionregv1.ValidatingWebhookConfiguration:
+		return hasAnyAnnotation(hook.Annotations, keys)
+	case *admissionregv1.MutatingWebhookConfiguration:
+		return hasAnyAnnotation(hook.Annotations, keys)
+	case cache.DeletedFinalStateUnknown:
+		return c.isAnnotated(hook.Obj)
+	}
+	return false
+}
+
+// isSource returns a filter accepting the objects of kind which are CA sources
+func (c *Controller) isSource(kind sourceKind) func(obj interface{}) bool {
+	return func(obj interface{}) bool {
+		if deleted, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+			obj = deleted.Obj
+		}
+		accessor, err := meta.Accessor(obj)
+		if err != nil {
+			return false
+		}
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.sources[caSourceRef{kind: kind, namespace: accessor.GetNamespace(), name: accessor.GetName()}]
+	}
+}
+
+// watchSources finds the CA sources named by the annotated webhook
+// configurations, and watches the ConfigMaps and Secrets of the namespaces
+// they are in until the namespace no longer has any, unless stop, which Run
+// stops every watch on, is already closed.
+// Annotations which can't be parsed are skipped: injecting reports them.
+func (c *Controller) watchSources(stop <-chan struct{}) {
+	annotations := []map[string]string{}
+	for _, informer := range []cache.SharedIndexInformer{c.validating, c.mutating} {
+		for _, obj := range informer.GetStore().List() {
+			if !c.isAnnotated(obj) {
+				continue
+			}
+			if accessor, err := meta.Accessor(obj); err == nil {
+				annotations = append(annotations, accessor.GetAnnotations())
+			}
+		}
+	}
+	sources := make(map[caSourceRef]bool)
+	namespaces := make(map[string]bool)
+	for _, a := range annotations {
+		parsed, err := c.injector.caSources(a)
+		if err != nil {
+			continue
+		}
+		for _, source := range parsed {
+			sources[caSourceRef{kind: source.kind, namespace: source.namespace, name: source.name}] = true
+			namespaces[source.namespace] = true
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	select {
+	case <-stop:
+		// Run has stopped the watches already
+		return
+	default:
+	}
+	c.sources = sources
+	for namespace, watch := range c.watches {
+		if !namespaces[namespace] {
+			log.Info("No longer watching CA sources", "namespace", namespace)
+			close(watch)
+			delete(c.watches, namespace)
+		}
+	}
+	for namespace := range namespaces {
+		if _, ok := c.watches[namespace]; ok {
+			continue
+		}
+		log.Info("Watching CA sources", "namespace", namespace)
+		watch := make(chan struct{})
+		c.watches[namespace] = watch
+		// The informers' initial list enqueues an injection, which picks up
+		// any change made before they started
+		local := informers.NewSharedInformerFactoryWithOptions(c.injector.clientset, c.resync, informers.WithNamespace(namespace))
+		local.Core().V1().ConfigMaps().Informer().AddEventHandler(c.handler(c.isSource(configMapSource)))
+		local.Core().V1().Secrets().Informer().AddEventHandler(c.handler(c.isSource(secretSource)))
+		local.Start(watch)
+	}
+}
+
+// stopWatches stops the informers of every namespace with sources
+func (c *Controller) stopWatches() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for namespace, watch := range c.watches {
+		close(watch)
+		delete(c.watches, namespace)
+	}
+}
+
+// Run injects CA bundles as needed until stop is closed. Injecting doesn't
+// loop on its own changes to a configuration, as configurations which already
+// have their CA bundle aren't written to.
+func (c *Controller) Run(stop <-chan struct{}) error {
+	cluster := informers.NewSharedInformerFactory(c.injector.clientset, c.resync)
+	c.validating = cluster.Admissionregistration().V1().ValidatingWebhookConfigurations().Informer()
+	c.mutating = cluster.Admissionregistration().V1().MutatingWebhookConfigurations().Informer()
+	// A change to a configuration may change which sources there are
+	configurations := cache.FilteringResourceEventHandler{
+		FilterFunc: c.isAnnotated,
+		Handler: cache.ResourceEventHandlerFuncs{
+			AddFunc: func(interface{}) {
+				c.watchSources(stop)
+				c.enqueue()
+			},
+			UpdateFunc: func(interface{}, interface{}) {
+				c.watchSources(stop)
+				c.enqueue()
+			},
+			DeleteFunc: func(interface{}) {
+				c.watchSources(stop)
+				c.enqueue()
+			},
+		},
+	}
+	c.validating.AddEventHandler(configurations)
+	c.mutating.AddEventHandler(configurations)
+	cluster.Start(stop)
+	defer c.stopWatches()
+	if !cache.WaitForCacheSync(stop, c.validating.HasSynced, c.mutating.HasSynced) {
+		return fmt.Errorf("couldn't sync informer caches")
+	}
+
+	log.Info("Watching webhook configurations", "resync", c.resync.String())
+	c.watchSources(stop)
+	c.enqueue()
+	for {
+		select {
+		case <-stop:
+			return nil
+		case <-c.queue:
+			if err := c.injector.Inject(); err != nil {
+				log.Error(err, "Couldn't inject CA bundles, retrying", "retryPeriod", retryPeriod.String())
+				time.AfterFunc(retryPeriod, c.enqueue)
+				continue
+			}
+			log.Info("Injected CA bundles")
+		}
+	}
+}
+
+// RunWithLeaderElection runs the controller only while identity holds the
+// LeaseName Lease in the controller's namespace, so that only one replica
+// writes to the webhook configurations. It returns once ctx is done or the
+// Lease is lost.
+func (c *Controller) RunWithLeaderElection(ctx context.Context, identity string) error {
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: v1.ObjectMeta{
+			Name:      LeaseName,
+			Namespace: c.namespace,
+		},
+		Client: c.injector.clientset.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity: identity,
+		},
+	}
+	started := make(chan struct{})
+	done := make(chan error, 1)
+	leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		Name:            LeaseName,
+		LeaseDuration:   15 * time.Second,
+		RenewDeadline:   10 * time.Second,
+		RetryPeriod:     2 * time.Second,
+		ReleaseOnCancel: true,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				log.Info("Started leading", "identity", identity)
+				close(started)
+				done <- c.Run(ctx.Done())
+			},
+			OnStoppedLeading: func() {
+				log.Info("Stopped leading", "identity", identity)
+			},
+		},
+	})
+	select {
+	case <-started:
+		// Run stops as soon as leading does
+		if err := <-done; err != nil {
+			return err
+		}
+	default:
+	}
+	if ctx.Err() == nil {
+		return fmt.Errorf("lost the %s lease", LeaseName)
+	}
+	return nil
+}
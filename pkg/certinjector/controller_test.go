@@ -0,0 +1,85 @@
+package certinjector
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// runController runs a Controller for injector, holding its Lease in test,
+// until the returned stop function is called
+func runController(injector *CertInjector) (<-chan error, func()) {
+	stop := make(chan struct{})
+	errs := make(chan error, 1)
+	go func() { errs <- NewController(injector, "test", time.Hour).Run(stop) }()
+	return errs, func() { close(stop) }
+}
+
+// waitForCABundle waits for the CA bundle of the ValidatingWebhookConfiguration
+// name to be the encoding of cert, well before the controller's resync
+func waitForCABundle(t *testing.T, injector *CertInjector, errs <-chan error, name, cert string) {
+	expected := injector.pemEncode(cert)
+	err := wait.PollImmediate(50*time.Millisecond, 10*time.Second, func() (bool, error) {
+		select {
+		case err := <-errs:
+			t.Fatalf("Controller stopped early: %v", err)
+		default:
+		}
+		got, err := injector.clientset.AdmissionregistrationV1().ValidatingWebhookConfigurations().Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		return string(got.Webhooks[0].ClientConfig.CABundle) == expected, nil
+	})
+	if err != nil {
+		t.Fatalf("Expected the CA bundle %q to be injected into %s, got %s", cert, name, err.Error())
+	}
+}
+
+func TestControllerInjectsWhenSourceChanges(t *testing.T) {
+	hook := createValidatingWebhookConfiguration("with", "test", map[string]string{InjectFromAnnotation: "test/service-ca"})
+	injector := newTestClient(hook)
+	errs, stop := runController(injector)
+	defer stop()
+
+	// The CA only shows up once the controller is running
+	cm := createConfigMap("service-ca", "test", nil, map[string]string{"service-ca.crt": "a-certificate"})
+	if _, err := injector.clientset.CoreV1().ConfigMaps("test").Create(context.TODO(), cm, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	waitForCABundle(t, injector, errs, "with", "a-certificate")
+}
+
+func TestControllerWatchesSourcesInOtherNamespaces(t *testing.T) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "ca", Namespace: "elsewhere"},
+		Data:       map[string][]byte{CACertKey: []byte("a-certificate")},
+	}
+	hook := createValidatingWebhookConfiguration("with", "test", map[string]string{InjectFromAnnotation: "secret:elsewhere/ca"})
+	injector := newTestClient(hook, secret)
+	errs, stop := runController(injector)
+	defer stop()
+	waitForCABundle(t, injector, errs, "with", "a-certificate")
+
+	// A rotated CA outside the controller's namespace is picked up
+	secret.Data[CACertKey] = []byte("a-rotated-certificate")
+	if _, err := injector.clientset.CoreV1().Secrets("elsewhere").Update(context.TODO(), secret, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	waitForCABundle(t, injector, errs, "with", "a-rotated-certificate")
+
+	// So are sources of configurations annotated after it started
+	other := createValidatingWebhookConfiguration("other", "test", map[string]string{InjectFromAnnotation: "another/ca/ca.crt"})
+	if _, err := injector.clientset.AdmissionregistrationV1().ValidatingWebhookConfigurations().Create(context.TODO(), other, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	cm := createConfigMap("ca", "another", nil, map[string]string{CACertKey: "another-certificate"})
+	if _, err := injector.clientset.CoreV1().ConfigMaps("another").Create(context.TODO(), cm, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	waitForCABundle(t, injector, errs, "other", "another-certificate")
+}
@@ -0,0 +1,67 @@
+package certinjector
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/tools/reference"
+)
+
+// syncRecorder is a record.EventRecorder which creates each Event before
+// returning. An EventBroadcaster's recorder sends them in the background,
+// which a run that exits right after injecting doesn't wait for, so its
+// Events would be lost. Unlike the broadcaster's, repeated Events aren't
+// aggregated.
+type syncRecorder struct {
+	events typedcorev1.EventsGetter
+	scheme *runtime.Scheme
+	source corev1.EventSource
+}
+
+var _ record.EventRecorder = &syncRecorder{}
+
+func (r *syncRecorder) Event(object runtime.Object, eventtype, reason, message string) {
+	r.AnnotatedEventf(object, nil, eventtype, reason, "%s", message)
+}
+
+func (r *syncRecorder) Eventf(object runtime.Object, eventtype, reason, messageFmt string, args ...interface{}) {
+	r.AnnotatedEventf(object, nil, eventtype, reason, messageFmt, args...)
+}
+
+// AnnotatedEventf creates the Event the way an EventBroadcaster's recorder
+// would. Failing to is only logged, as recording Events is best effort.
+func (r *syncRecorder) AnnotatedEventf(object runtime.Object, annotations map[string]string, eventtype, reason, messageFmt string, args ...interface{}) {
+	ref, err := reference.GetReference(r.scheme, object)
+	if err != nil {
+		log.Error(err, "Couldn't reference the object of an Event", "reason", reason)
+		return
+	}
+	namespace := ref.Namespace
+	if namespace == "" {
+		namespace = v1.NamespaceDefault
+	}
+	now := v1.Now()
+	event := &corev1.Event{
+		ObjectMeta: v1.ObjectMeta{
+			Name:        fmt.Sprintf("%v.%x", ref.Name, now.UnixNano()),
+			Namespace:   namespace,
+			Annotations: annotations,
+		},
+		InvolvedObject: *ref,
+		Reason:         reason,
+		Message:        fmt.Sprintf(messageFmt, args...),
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+		Type:           eventtype,
+		Source:         r.source,
+	}
+	if _, err := r.events.Events(namespace).Create(context.TODO(), event, v1.CreateOptions{}); err != nil {
+		log.Error(err, "Couldn't create Event", "reason", reason, "object", ref.Name)
+	}
+}
@@ -3,59 +3,166 @@ package certinjector
 import (
 	"context"
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"strings"
 	"sync"
 
 	admissionregv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/rest"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/record"
 )
 
-// CertInjector will give a way to inject cert information into ValidationWebhookConfiguration Kubernets objects
+const (
+	// InjectionFailedReason is the reason of the Warning Events emitted on
+	// webhook configurations whose CA bundle couldn't be injected
+	InjectionFailedReason string = "CABundleInjectionFailed"
+	// FieldManager is the field manager the injector writes CA bundles as
+	FieldManager string = "cert-injector"
+)
+
+// CertInjector will give a way to inject cert information into ValidationWebhookConfiguration and MutatingWebhookConfiguration Kubernets objects
 type CertInjector struct {
 	mu        sync.Mutex
 	clientset kubernetes.Interface
 	scheme    runtime.Scheme
+	recorder  record.EventRecorder
+
+	annotation       string
+	secretAnnotation string
+	configMapKey     string
+}
+
+// Options configure a CertInjector. Their zero values are the defaults.
+type Options struct {
+	// Annotation names the CA sources of a webhook configuration, defaulting
+	// to ConfigMaps. It defaults to InjectFromAnnotation.
+	Annotation string
+	// SecretAnnotation names the CA sources of a webhook configuration,
+	// defaulting to Secrets. It defaults to InjectFromSecretAnnotation.
+	SecretAnnotation string
+	// ConfigMapKey is the key of the CA certificate in ConfigMap sources which
+	// don't name one. It defaults to ServiceCACertKey.
+	ConfigMapKey string
+	// Kubeconfig is the path of the kubeconfig NewCertInjector connects with.
+	// It defaults to the in-cluster configuration.
+	Kubeconfig string
+	// Recorder records the Events emitted on webhook configurations. It
+	// defaults to one which emits them through the clientset.
+	Recorder record.EventRecorder
+	// SyncEvents has the default Recorder create each Event before the call
+	// emitting it returns, as runs which exit right after injecting need.
+	// Otherwise Events are sent in the background by an EventBroadcaster,
+	// which aggregates repeated ones.
+	SyncEvents bool
+}
+
+// NewCertInjector returns a CertInjector connected to the cluster of
+// opts.Kubeconfig, or to the one it runs in.
+func NewCertInjector(opts Options) (*CertInjector, error) {
+	config, err := clientcmd.BuildConfigFromFlags("", opts.Kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return NewCertInjectorWithClient(clientset, opts), nil
 }
 
-func NewCertInjector() *CertInjector {
+// NewCertInjectorWithClient returns a CertInjector which uses clientset.
+// opts.Kubeconfig is ignored.
+func NewCertInjectorWithClient(clientset kubernetes.Interface, opts Options) *CertInjector {
 	scheme := runtime.NewScheme()
 	err := admissionregv1.AddToScheme(scheme)
 	if err != nil {
 		panic(err.Error())
 	}
-	config, err := rest.InClusterConfig()
-	if err != nil {
-		panic(err.Error())
+	if opts.Annotation == "" {
+		opts.Annotation = InjectFromAnnotation
+	}
+	if opts.SecretAnnotation == "" {
+		opts.SecretAnnotation = InjectFromSecretAnnotation
+	}
+	if opts.ConfigMapKey == "" {
+		opts.ConfigMapKey = ServiceCACertKey
+	}
+	if opts.Recorder == nil {
+		opts.Recorder = newEventRecorder(clientset, scheme, opts.SyncEvents)
 	}
-	clientset := kubernetes.NewForConfigOrDie(config)
 	return &CertInjector{
-		clientset: clientset,
-		scheme:    *scheme,
+		clientset:        clientset,
+		scheme:           *scheme,
+		recorder:         opts.Recorder,
+		annotation:       opts.Annotation,
+		secretAnnotation: opts.SecretAnnotation,
+		configMapKey:     opts.ConfigMapKey,
+	}
+}
+
+// annotationKeys are the annotations of the webhook configurations the
+// CertInjector injects into
+func (c *CertInjector) annotationKeys() []string {
+	return []string{c.annotation, c.secretAnnotation}
+}
+
+// newEventRecorder returns the default recorder of a CertInjector, see
+// Options.SyncEvents
+func newEventRecorder(clientset kubernetes.Interface, scheme *runtime.Scheme, syncEvents bool) record.EventRecorder {
+	source := corev1.EventSource{Component: "cert-injector"}
+	if syncEvents {
+		return &syncRecorder{events: clientset.CoreV1(), scheme: scheme, source: source}
 	}
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: clientset.CoreV1().Events("")})
+	return broadcaster.NewRecorder(scheme, source)
 }
 
 func (c *CertInjector) getCACert(name, namespace string) (string, error) {
+	return c.getCACertFromKey(name, namespace, c.configMapKey)
+}
+
+// getCACertFromKey returns the certificate under key in the ConfigMap
+// namespace/name
+func (c *CertInjector) getCACertFromKey(name, namespace, key string) (string, error) {
 	cm, err := c.clientset.CoreV1().ConfigMaps(namespace).Get(context.TODO(), name, v1.GetOptions{})
 	if err != nil {
 		return "", err
 	}
-	if _, ok := cm.Data["service-ca.crt"]; !ok {
-		return "", fmt.Errorf("No service-ca.crt found in ConfigMap")
+	if _, ok := cm.Data[key]; !ok {
+		return "", fmt.Errorf("No %s found in ConfigMap %s/%s", key, namespace, name)
 	}
-	return cm.Data["service-ca.crt"], nil
+	return cm.Data[key], nil
+}
+
+// getCACertFromSecret returns the certificate under key in the Secret
+// namespace/name
+func (c *CertInjector) getCACertFromSecret(name, namespace, key string) (string, error) {
+	secret, err := c.clientset.CoreV1().Secrets(namespace).Get(context.TODO(), name, v1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	if _, ok := secret.Data[key]; !ok {
+		return "", fmt.Errorf("No %s found in Secret %s/%s", key, namespace, name)
+	}
+	return string(secret.Data[key]), nil
 }
 
 func (c *CertInjector) pemEncode(cert string) string {
 	return base64.RawStdEncoding.EncodeToString([]byte(strings.TrimSpace(cert)))
 }
 
-// getValidatingWebhooks returns all ValidatingWebhooks that have the
-// annotationKey present
-func (c *CertInjector) getValidatingWebhooks(annotationKey string) ([]admissionregv1.ValidatingWebhookConfiguration, error) {
+// getValidatingWebhooks returns all ValidatingWebhooks that have any of the
+// annotationKeys present
+func (c *CertInjector) getValidatingWebhooks(annotationKeys ...string) ([]admissionregv1.ValidatingWebhookConfiguration, error) {
 	ret := make([]admissionregv1.ValidatingWebhookConfiguration, 0)
 	hooks, err := c.clientset.
 		AdmissionregistrationV1().
@@ -66,41 +173,188 @@ func (c *CertInjector) getValidatingWebhooks(annotationKey string) ([]admissionr
 	}
 
 	for _, hook := range hooks.Items {
-		if _, ok := hook.Annotations[annotationKey]; ok {
+		if hasAnyAnnotation(hook.Annotations, annotationKeys) {
 			ret = append(ret, hook)
 		}
 	}
 	return ret, nil
 }
 
+// getMutatingWebhooks returns all MutatingWebhooks that have any of the
+// annotationKeys present
+func (c *CertInjector) getMutatingWebhooks(annotationKeys ...string) ([]admissionregv1.MutatingWebhookConfiguration, error) {
+	ret := make([]admissionregv1.MutatingWebhookConfiguration, 0)
+	hooks, err := c.clientset.
+		AdmissionregistrationV1().
+		MutatingWebhookConfigurations().
+		List(context.TODO(), v1.ListOptions{})
+	if err != nil {
+		return ret, err
+	}
+
+	for _, hook := range hooks.Items {
+		if hasAnyAnnotation(hook.Annotations, annotationKeys) {
+			ret = append(ret, hook)
+		}
+	}
+	return ret, nil
+}
+
+func hasAnyAnnotation(annotations map[string]string, keys []string) bool {
+	for _, key := range keys {
+		if _, ok := annotations[key]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// caSources returns the CA sources named by the annotations of a webhook
+// configuration: either the CertInjector's annotation, whose sources default
+// to ConfigMaps, or its secret annotation, whose sources default to Secrets.
+// See parseSources for their format.
+func (c *CertInjector) caSources(annotations map[string]string) ([]caSource, error) {
+	key := c.annotation
+	defaultKind := configMapSource
+	value, ok := annotations[key]
+	if !ok {
+		key = c.secretAnnotation
+		defaultKind = secretSource
+		value = annotations[key]
+	}
+	sources, err := parseSources(value, defaultKind, c.configMapKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s annotation: %w", key, err)
+	}
+	return sources, nil
+}
+
+// getEncodedCACert returns the encoded CA bundle named by the annotations of
+// a webhook configuration, see caSources.
+func (c *CertInjector) getEncodedCACert(annotations map[string]string) (string, error) {
+	sources, err := c.caSources(annotations)
+	if err != nil {
+		return "", err
+	}
+
+	certs := make([]string, 0, len(sources))
+	for _, source := range sources {
+		getCACert := c.getCACertFromKey
+		if source.kind == secretSource {
+			getCACert = c.getCACertFromSecret
+		}
+		cert, err := getCACert(source.name, source.namespace, source.key)
+		if err != nil {
+			return "", err
+		}
+		certs = append(certs, strings.TrimSpace(cert))
+	}
+	return c.pemEncode(strings.Join(certs, "\n")), nil
+}
+
+// Inject injects the CA bundle named by their annotations into every
+// annotated webhook configuration. A configuration which fails doesn't stop
+// the others: its error is emitted as a Warning Event on it, and all of them
+// are returned together.
 func (c *CertInjector) Inject() error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	allHooks, err := c.getValidatingWebhooks("managed.openshift.io/inject-cabundle-from")
+	errs := []error{}
+
+	allHooks, err := c.getValidatingWebhooks(c.annotationKeys()...)
 	if err != nil {
-		return err
+		errs = append(errs, err)
 	}
 	for i := range allHooks {
-		src := allHooks[i].Annotations["managed.openshift.io/inject-cabundle-from"]
-		// need to inject from "src"
-		split := strings.Split(src, "/")
-		namespace := split[0]
-		configMapSource := split[1]
+		if err := c.injectValidating(&allHooks[i]); err != nil {
+			c.recorder.Event(&allHooks[i], corev1.EventTypeWarning, InjectionFailedReason, err.Error())
+			errs = append(errs, fmt.Errorf("ValidatingWebhookConfiguration %s: %w", allHooks[i].Name, err))
+		}
+	}
 
-		cert, err := c.getCACert(configMapSource, namespace)
-		if err != nil {
-			return err
+	allMutatingHooks, err := c.getMutatingWebhooks(c.annotationKeys()...)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	for i := range allMutatingHooks {
+		if err := c.injectMutating(&allMutatingHooks[i]); err != nil {
+			c.recorder.Event(&allMutatingHooks[i], corev1.EventTypeWarning, InjectionFailedReason, err.Error())
+			errs = append(errs, fmt.Errorf("MutatingWebhookConfiguration %s: %w", allMutatingHooks[i].Name, err))
 		}
-		encoded := c.pemEncode(cert)
-		for j := range allHooks[i].Webhooks {
-			if string(allHooks[i].Webhooks[j].ClientConfig.CABundle) != encoded {
-				allHooks[i].Webhooks[j].ClientConfig.CABundle = []byte(encoded)
-			}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+func (c *CertInjector) injectValidating(hook *admissionregv1.ValidatingWebhookConfiguration) error {
+	encoded, err := c.getEncodedCACert(hook.Annotations)
+	if err != nil {
+		return err
+	}
+	webhooks := make([]admissionregv1.WebhookClientConfig, len(hook.Webhooks))
+	names := make([]string, len(hook.Webhooks))
+	for j := range hook.Webhooks {
+		webhooks[j] = hook.Webhooks[j].ClientConfig
+		names[j] = hook.Webhooks[j].Name
+	}
+	patch, err := caBundlePatch(encoded, names, webhooks)
+	if err != nil || patch == nil {
+		return err
+	}
+	_, err = c.clientset.
+		AdmissionregistrationV1().
+		ValidatingWebhookConfigurations().
+		Patch(context.TODO(), hook.Name, types.JSONPatchType, patch, v1.PatchOptions{FieldManager: FieldManager})
+	return err
+}
+
+func (c *CertInjector) injectMutating(hook *admissionregv1.MutatingWebhookConfiguration) error {
+	encoded, err := c.getEncodedCACert(hook.Annotations)
+	if err != nil {
+		return err
+	}
+	webhooks := make([]admissionregv1.WebhookClientConfig, len(hook.Webhooks))
+	names := make([]string, len(hook.Webhooks))
+	for j := range hook.Webhooks {
+		webhooks[j] = hook.Webhooks[j].ClientConfig
+		names[j] = hook.Webhooks[j].Name
+	}
+	patch, err := caBundlePatch(encoded, names, webhooks)
+	if err != nil || patch == nil {
+		return err
+	}
+	_, err = c.clientset.
+		AdmissionregistrationV1().
+		MutatingWebhookConfigurations().
+		Patch(context.TODO(), hook.Name, types.JSONPatchType, patch, v1.PatchOptions{FieldManager: FieldManager})
+	return err
+}
+
+// jsonPatchOperation is an RFC 6902 JSON patch operation
+type jsonPatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value"`
+}
+
+// caBundlePatch returns a JSON patch setting the CA bundle of the webhooks
+// named names, whose client configs are clientConfigs, to encoded, or nil if
+// they all have it already. Only the CA bundles are written, so the patch
+// doesn't conflict with whatever else manages the configuration, and each is
+// guarded by a test of its webhook's name, so the patch fails rather than
+// writes to the wrong webhook if they were reordered in the meantime.
+func caBundlePatch(encoded string, names []string, clientConfigs []admissionregv1.WebhookClientConfig) ([]byte, error) {
+	ops := []jsonPatchOperation{}
+	for j := range clientConfigs {
+		if string(clientConfigs[j].CABundle) == encoded {
+			continue
 		}
-		c.clientset.
-			AdmissionregistrationV1().
-			ValidatingWebhookConfigurations().
-			Update(context.TODO(), &allHooks[i], v1.UpdateOptions{})
+		ops = append(ops,
+			jsonPatchOperation{Op: "test", Path: fmt.Sprintf("/webhooks/%d/name", j), Value: names[j]},
+			jsonPatchOperation{Op: "add", Path: fmt.Sprintf("/webhooks/%d/clientConfig/caBundle", j), Value: []byte(encoded)},
+		)
+	}
+	if len(ops) == 0 {
+		return nil, nil
 	}
-	return nil
+	return json.Marshal(ops)
 }
@@ -3,6 +3,7 @@ package certinjector
 import (
 	"context"
 	"fmt"
+	"strings"
 	"testing"
 
 	admissionregv1 "k8s.io/api/admissionregistration/v1"
@@ -10,7 +11,9 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 	kubernetes "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/utils/pointer"
 )
 
@@ -40,23 +43,7 @@ rqXRfboQnoZsG4q5WTP468SQvvG5
 )
 
 func newTestClient(objs ...runtime.Object) *CertInjector {
-	s := runtime.NewScheme()
-	err := admissionregv1.AddToScheme(s)
-	if err != nil {
-		panic(err.Error())
-	}
-	err = corev1.AddToScheme((s))
-	if err != nil {
-		panic(err.Error())
-	}
-	clientset := kubernetes.NewSimpleClientset(objs...)
-
-	d := &CertInjector{
-		scheme:    *s,
-		clientset: clientset,
-	}
-
-	return d
+	return NewCertInjectorWithClient(kubernetes.NewSimpleClientset(objs...), Options{Recorder: record.NewFakeRecorder(10)})
 }
 
 func createConfigMap(name, namespace string, annotations, data map[string]string) *corev1.ConfigMap {
@@ -107,6 +94,39 @@ func createValidatingWebhookConfiguration(name, namespace string, annotations ma
 	}
 }
 
+func createMutatingWebhookConfiguration(name, namespace string, annotations map[string]string) *admissionregv1.MutatingWebhookConfiguration {
+	scope := admissionregv1.NamespacedScope
+	return &admissionregv1.MutatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Annotations: annotations,
+		},
+		Webhooks: []admissionregv1.MutatingWebhook{
+			{
+				Rules: []admissionregv1.RuleWithOperations{
+					{
+						Operations: []admissionregv1.OperationType{"CREATE"},
+						Rule: admissionregv1.Rule{
+							APIGroups:   []string{""},
+							APIVersions: []string{"*"},
+							Resources:   []string{"pods"},
+							Scope:       &scope,
+						},
+					},
+				},
+				Name: fmt.Sprintf("%s-hook.managed.openshift.io", name),
+				ClientConfig: admissionregv1.WebhookClientConfig{
+					Service: &admissionregv1.ServiceReference{
+						Namespace: namespace,
+						Path:      pointer.StringPtr(fmt.Sprintf("/%s-hook", name)),
+						Name:      name,
+					},
+				},
+			},
+		},
+	}
+}
+
 func TestEncode(t *testing.T) {
 	injector := newTestClient()
 	encoded := injector.pemEncode(certString)
@@ -183,3 +203,197 @@ func TestInject(t *testing.T) {
 	}
 
 }
+
+func TestGetMutatingWebhooks(t *testing.T) {
+	withAnnotation := createMutatingWebhookConfiguration("with", "test", map[string]string{"managed.openshift.io/inject-cabundle-from": "test/with"})
+	withoutAnnotation := createMutatingWebhookConfiguration("without", "test", map[string]string{})
+	injector := newTestClient(withAnnotation, withoutAnnotation)
+	hooks, err := injector.getMutatingWebhooks("managed.openshift.io/inject-cabundle-from")
+	if err != nil {
+		t.Fatalf("Got an unexpected error: %s", err.Error())
+	}
+	if len(hooks) != 1 {
+		t.Fatalf("Expected to get 1 hook, got %d", len(hooks))
+	}
+}
+
+func TestInjectMutating(t *testing.T) {
+	withAnnotation := createMutatingWebhookConfiguration("with", "test", map[string]string{"managed.openshift.io/inject-cabundle-from": "test/with"})
+	withoutAnnotation := createMutatingWebhookConfiguration("without", "test", map[string]string{})
+	cm := createConfigMap("with", "test",
+		map[string]string{"service.beta.openshift.io/inject-cabundle": "true"},
+		map[string]string{"service-ca.crt": certString})
+	injector := newTestClient(withAnnotation, withoutAnnotation, cm)
+	err := injector.Inject()
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	webhook, err := injector.clientset.
+		AdmissionregistrationV1().
+		MutatingWebhookConfigurations().
+		Get(context.TODO(), "with", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if len(webhook.Webhooks[0].ClientConfig.CABundle) == 0 {
+		t.Fatalf("MutatingWebhookConfiguration %s, webhook %s missing CA Bundle", webhook.GetName(), webhook.Webhooks[0].Name)
+	}
+	webhook, err = injector.clientset.
+		AdmissionregistrationV1().
+		MutatingWebhookConfigurations().
+		Get(context.TODO(), "without", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if len(webhook.Webhooks[0].ClientConfig.CABundle) != 0 {
+		t.Fatalf("MutatingWebhookConfiguration %s has no annotation but got a CA Bundle", webhook.GetName())
+	}
+}
+
+func TestInjectCollectsFailures(t *testing.T) {
+	good := createValidatingWebhookConfiguration("good", "test", map[string]string{InjectFromAnnotation: "test/with"})
+	malformed := createValidatingWebhookConfiguration("malformed", "test", map[string]string{InjectFromAnnotation: "with"})
+	missing := createMutatingWebhookConfiguration("missing", "test", map[string]string{InjectFromAnnotation: "test/missing"})
+	cm := createConfigMap("with", "test", nil, map[string]string{"service-ca.crt": certString})
+	injector := newTestClient(good, malformed, missing, cm)
+
+	err := injector.Inject()
+	aggregate, ok := err.(utilerrors.Aggregate)
+	if !ok || len(aggregate.Errors()) != 2 {
+		t.Fatalf("Expected 2 aggregated errors, got %v", err)
+	}
+	for _, name := range []string{"malformed", "missing"} {
+		if !strings.Contains(err.Error(), name) {
+			t.Fatalf("Expected the error to name %s, got %s", name, err.Error())
+		}
+	}
+
+	// The failures don't stop the other configurations from being injected
+	webhook, err := injector.clientset.
+		AdmissionregistrationV1().
+		ValidatingWebhookConfigurations().
+		Get(context.TODO(), "good", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if len(webhook.Webhooks[0].ClientConfig.CABundle) == 0 {
+		t.Fatalf("ValidatingWebhookConfiguration %s missing CA Bundle", webhook.GetName())
+	}
+
+	events := injector.recorder.(*record.FakeRecorder).Events
+	for i := 0; i < 2; i++ {
+		select {
+		case event := <-events:
+			if !strings.HasPrefix(event, "Warning "+InjectionFailedReason) {
+				t.Fatalf("Expected a %s Warning, got %s", InjectionFailedReason, event)
+			}
+		default:
+			t.Fatalf("Expected an Event for each failure, got %d", i)
+		}
+	}
+}
+
+func TestInjectCreatesFailureEventsSynchronously(t *testing.T) {
+	missing := createValidatingWebhookConfiguration("missing", "test", map[string]string{InjectFromAnnotation: "test/missing"})
+	injector := NewCertInjectorWithClient(kubernetes.NewSimpleClientset(missing), Options{SyncEvents: true})
+
+	if err := injector.Inject(); err == nil {
+		t.Fatalf("Expected an error injecting from a missing ConfigMap")
+	}
+	// A run which exits right after injecting only keeps the Events which
+	// already exist by then
+	events, err := injector.clientset.CoreV1().Events(metav1.NamespaceDefault).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if len(events.Items) != 1 {
+		t.Fatalf("Expected 1 Event, got %d", len(events.Items))
+	}
+	event := events.Items[0]
+	if event.Type != corev1.EventTypeWarning || event.Reason != InjectionFailedReason {
+		t.Fatalf("Expected a %s Warning, got %s %s", InjectionFailedReason, event.Type, event.Reason)
+	}
+	if event.InvolvedObject.Kind != "ValidatingWebhookConfiguration" || event.InvolvedObject.Name != "missing" {
+		t.Fatalf("Expected the Event to be on ValidatingWebhookConfiguration missing, got %s %s", event.InvolvedObject.Kind, event.InvolvedObject.Name)
+	}
+}
+
+func TestInjectOnlyPatchesChanges(t *testing.T) {
+	hook := createValidatingWebhookConfiguration("with", "test", map[string]string{InjectFromAnnotation: "test/with"})
+	cm := createConfigMap("with", "test", nil, map[string]string{"service-ca.crt": certString})
+	injector := newTestClient(hook, cm)
+	clientset := injector.clientset.(*kubernetes.Clientset)
+
+	writes := func() []string {
+		verbs := []string{}
+		for _, action := range clientset.Actions() {
+			if action.GetResource().Resource == "validatingwebhookconfigurations" && action.GetVerb() != "list" {
+				verbs = append(verbs, action.GetVerb())
+			}
+		}
+		return verbs
+	}
+	if err := injector.Inject(); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if verbs := writes(); len(verbs) != 1 || verbs[0] != "patch" {
+		t.Fatalf("Expected a single patch, got %v", verbs)
+	}
+	webhook, err := injector.clientset.
+		AdmissionregistrationV1().
+		ValidatingWebhookConfigurations().
+		Get(context.TODO(), "with", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if string(webhook.Webhooks[0].ClientConfig.CABundle) != injector.pemEncode(certString) {
+		t.Fatalf("Expected the CA bundle to be patched in, got %q", string(webhook.Webhooks[0].ClientConfig.CABundle))
+	}
+	if len(webhook.Webhooks[0].Rules) != len(hook.Webhooks[0].Rules) {
+		t.Fatalf("Expected the rest of the webhook to be left alone, got %+v", webhook.Webhooks[0])
+	}
+
+	clientset.ClearActions()
+	if err := injector.Inject(); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if verbs := writes(); len(verbs) != 0 {
+		t.Fatalf("Expected an unchanged CA bundle not to be written, got %v", verbs)
+	}
+}
+
+func TestInjectWithOptions(t *testing.T) {
+	custom := createValidatingWebhookConfiguration("custom", "test", map[string]string{"example.com/ca-from": "test/cas"})
+	standard := createValidatingWebhookConfiguration("standard", "test", map[string]string{InjectFromAnnotation: "test/cas"})
+	cm := createConfigMap("cas", "test", nil, map[string]string{"ca-bundle.crt": certString})
+	injector := NewCertInjectorWithClient(kubernetes.NewSimpleClientset(custom, standard, cm), Options{
+		Annotation:   "example.com/ca-from",
+		ConfigMapKey: "ca-bundle.crt",
+		Recorder:     record.NewFakeRecorder(10),
+	})
+	if err := injector.Inject(); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	for name, expected := range map[string]string{
+		"custom": injector.pemEncode(certString),
+		// Only the configured annotation is injected
+		"standard": "",
+	} {
+		webhook, err := injector.clientset.
+			AdmissionregistrationV1().
+			ValidatingWebhookConfigurations().
+			Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err.Error())
+		}
+		if string(webhook.Webhooks[0].ClientConfig.CABundle) != expected {
+			t.Fatalf("ValidatingWebhookConfiguration %s: expected CA bundle %q, got %q", name, expected, string(webhook.Webhooks[0].ClientConfig.CABundle))
+		}
+	}
+}
+
+func TestNewCertInjectorWithMissingKubeconfig(t *testing.T) {
+	if _, err := NewCertInjector(Options{Kubeconfig: "/nonexistent/kubeconfig"}); err == nil {
+		t.Fatalf("Expected an error for a missing kubeconfig")
+	}
+}
@@ -0,0 +1,49 @@
+package certinjector
+
+import (
+	"fmt"
+)
+
+// Provisioning is how the webhook server's serving certificate is issued,
+// and how its CA gets into the caBundle of the webhook configurations.
+type Provisioning string
+
+const (
+	// ServiceCA has OpenShift's service-ca operator issue the certificate into
+	// the Secret named by the Service's serving-cert-secret-name annotation,
+	// and publish its CA into a ConfigMap, which the injector copies from. It
+	// is the default.
+	ServiceCA Provisioning = "service-ca"
+	// CertManager has cert-manager issue the certificate from a Certificate
+	// and Issuer, and its cainjector inject the CA into webhook configurations
+	// annotated with cert-manager.io/inject-ca-from. The injector isn't
+	// needed.
+	CertManager Provisioning = "cert-manager"
+	// SelfSigned has the injector generate a CA and certificate, keep them in
+	// a Secret shared by all replicas and inject the CA from there.
+	SelfSigned Provisioning = "self-signed"
+
+	// InjectFromAnnotation on a webhook configuration names the sources of
+	// its CA bundle, by default the namespace/name of the ConfigMap whose
+	// service-ca.crt is its CA bundle. Sources can also name another key,
+	// Secrets, or several certificates to trust at once, as in
+	// "ns/cm/new-ca.crt,secret:ns/old-ca".
+	InjectFromAnnotation string = "managed.openshift.io/inject-cabundle-from"
+	// InjectFromSecretAnnotation on a webhook configuration is like
+	// InjectFromAnnotation, but its sources default to Secrets, and their
+	// ca.crt
+	InjectFromSecretAnnotation string = "managed.openshift.io/inject-cabundle-from-secret"
+	// CertManagerInjectAnnotation on a webhook configuration names the
+	// namespace/name of the cert-manager Certificate whose CA cert-manager
+	// injects
+	CertManagerInjectAnnotation string = "cert-manager.io/inject-ca-from"
+)
+
+// ParseProvisioning checks that s names a Provisioning.
+func ParseProvisioning(s string) (Provisioning, error) {
+	switch p := Provisioning(s); p {
+	case ServiceCA, CertManager, SelfSigned:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown certificate provisioning %q, expected one of %s, %s or %s", s, ServiceCA, CertManager, SelfSigned)
+}
@@ -0,0 +1,289 @@
+package certinjector
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// CACertKey is the key of the CA certificate in a self-signed Secret,
+	// alongside the serving certificate's tls.crt and tls.key
+	CACertKey string = "ca.crt"
+	// PreviousCACertKey is the key of the CA which the CA of a self-signed
+	// Secret replaced, which webhook configurations keep trusting so that
+	// replicas still serving a certificate it signed aren't rejected. It is
+	// the current CA until that is first replaced, and once the previous one
+	// expires.
+	PreviousCACertKey string = "ca-previous.crt"
+	// caKeyKey is the key of the CA's private key, which is only kept in the
+	// Secret so that the serving certificate can be reissued
+	caKeyKey string = "ca.key"
+
+	caValidity      = 5 * 365 * 24 * time.Hour
+	servingValidity = 365 * 24 * time.Hour
+	// renewBefore is how long before they expire certificates are replaced
+	renewBefore = 30 * 24 * time.Hour
+)
+
+// SelfSignedDNSNames are the names a Service's serving certificate is issued
+// for.
+func SelfSignedDNSNames(service, namespace string) []string {
+	return []string{
+		service,
+		fmt.Sprintf("%s.%s", service, namespace),
+		fmt.Sprintf("%s.%s.svc", service, namespace),
+		fmt.Sprintf("%s.%s.svc.cluster.local", service, namespace),
+	}
+}
+
+// EnsureSelfSignedSecret returns the Secret namespace/name holding a CA and a
+// serving certificate for service signed by it, creating it if need be. The
+// serving certificate is reissued when it nears expiry or doesn't cover the
+// Service's names, reusing the CA unless that nears expiry too, so that the
+// CA bundle in the webhook configurations only changes when it must. When the
+// CA is replaced, the old one is kept as PreviousCACertKey, which the webhook
+// configurations should trust alongside CACertKey. All replicas share the
+// Secret, so whichever creates it first wins.
+func (c *CertInjector) EnsureSelfSignedSecret(namespace, name, service string) (*corev1.Secret, error) {
+	dnsNames := SelfSignedDNSNames(service, namespace)
+	now := time.Now()
+	secrets := c.clientset.CoreV1().Secrets(namespace)
+
+	secret, err := secrets.Get(context.TODO(), name, v1.GetOptions{})
+	if errors.IsNotFound(err) {
+		data, err := generateSelfSigned(nil, nil, dnsNames, now)
+		if err != nil {
+			return nil, err
+		}
+		data[PreviousCACertKey] = data[CACertKey]
+		secret = &corev1.Secret{
+			ObjectMeta: v1.ObjectMeta{
+				Name:      name,
+				Namespace: namespace,
+			},
+			Type: corev1.SecretTypeTLS,
+			Data: data,
+		}
+		created, err := secrets.Create(context.TODO(), secret, v1.CreateOptions{})
+		if errors.IsAlreadyExists(err) {
+			return secrets.Get(context.TODO(), name, v1.GetOptions{})
+		}
+		return created, err
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ca, caKey := validCA(secret.Data, now)
+	if ca != nil && validServingCert(secret.Data, ca, dnsNames, now) {
+		previous := previousCA(secret.Data[PreviousCACertKey], secret.Data[CACertKey], now)
+		if bytes.Equal(previous, secret.Data[PreviousCACertKey]) {
+			return secret, nil
+		}
+		secret.Data[PreviousCACertKey] = previous
+		return secrets.Update(context.TODO(), secret, v1.UpdateOptions{})
+	}
+	data, err := generateSelfSigned(ca, caKey, dnsNames, now)
+	if err != nil {
+		return nil, err
+	}
+	if ca == nil {
+		// Replicas keep serving certificates signed by the replaced CA until
+		// they pick up the reissued one
+		data[PreviousCACertKey] = previousCA(secret.Data[CACertKey], data[CACertKey], now)
+	} else {
+		data[PreviousCACertKey] = previousCA(secret.Data[PreviousCACertKey], data[CACertKey], now)
+	}
+	secret.Data = data
+	return secrets.Update(context.TODO(), secret, v1.UpdateOptions{})
+}
+
+// previousCA returns the previous CA certificate, unless it is missing or
+// expired, in which case there is nothing left to trust it for and the current
+// one is returned.
+func previousCA(previous, current []byte, now time.Time) []byte {
+	cert, err := parseCertificate(previous)
+	if err != nil || now.After(cert.NotAfter) {
+		return current
+	}
+	return previous
+}
+
+// WriteCertificates writes the certificates in secret into dir, where the
+// webhook server reads them from. Files which are already up to date are left
+// alone, so that calling it periodically only rotates the webhook server's
+// certificates when they change.
+func WriteCertificates(dir string, secret *corev1.Secret) error {
+	for key, mode := range map[string]os.FileMode{
+		corev1.TLSCertKey:       0644,
+		corev1.TLSPrivateKeyKey: 0600,
+		CACertKey:               0644,
+	} {
+		data, ok := secret.Data[key]
+		if !ok {
+			return fmt.Errorf("No %s found in Secret %s/%s", key, secret.Namespace, secret.Name)
+		}
+		path := filepath.Join(dir, key)
+		if current, err := ioutil.ReadFile(path); err == nil && bytes.Equal(current, data) {
+			continue
+		}
+		if err := ioutil.WriteFile(path, data, mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validCA returns the CA in data and its key, unless they are missing,
+// invalid or near expiry.
+func validCA(data map[string][]byte, now time.Time) (*x509.Certificate, crypto.Signer) {
+	ca, err := parseCertificate(data[CACertKey])
+	if err != nil || !ca.IsCA || now.Add(renewBefore).After(ca.NotAfter) {
+		return nil, nil
+	}
+	caKey, err := parseKey(data[caKeyKey])
+	if err != nil {
+		return nil, nil
+	}
+	return ca, caKey
+}
+
+// validServingCert reports whether the serving certificate in data is signed
+// by ca, covers dnsNames and isn't near expiry.
+func validServingCert(data map[string][]byte, ca *x509.Certificate, dnsNames []string, now time.Time) bool {
+	cert, err := parseCertificate(data[corev1.TLSCertKey])
+	if err != nil || now.Add(renewBefore).After(cert.NotAfter) {
+		return false
+	}
+	if _, err := parseKey(data[corev1.TLSPrivateKeyKey]); err != nil {
+		return false
+	}
+	if err := cert.CheckSignatureFrom(ca); err != nil {
+		return false
+	}
+	for _, name := range dnsNames {
+		if err := cert.VerifyHostname(name); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// generateSelfSigned issues a serving certificate for dnsNames signed by ca,
+// generating a new CA first if ca is nil, and returns the contents of the
+// Secret holding them.
+func generateSelfSigned(ca *x509.Certificate, caKey crypto.Signer, dnsNames []string, now time.Time) (map[string][]byte, error) {
+	var err error
+	if ca == nil {
+		if caKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+			return nil, err
+		}
+		template, err := certificateTemplate(fmt.Sprintf("%s-ca@%d", dnsNames[0], now.Unix()), now, caValidity)
+		if err != nil {
+			return nil, err
+		}
+		template.IsCA = true
+		template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+		der, err := x509.CreateCertificate(rand.Reader, template, template, caKey.Public(), caKey)
+		if err != nil {
+			return nil, err
+		}
+		if ca, err = x509.ParseCertificate(der); err != nil {
+			return nil, err
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	template, err := certificateTemplate(dnsNames[len(dnsNames)-1], now, servingValidity)
+	if err != nil {
+		return nil, err
+	}
+	template.DNSNames = dnsNames
+	template.KeyUsage = x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, key.Public(), caKey)
+	if err != nil {
+		return nil, err
+	}
+
+	keyPEM, err := encodeKey(key)
+	if err != nil {
+		return nil, err
+	}
+	caKeyPEM, err := encodeKey(caKey)
+	if err != nil {
+		return nil, err
+	}
+	return map[string][]byte{
+		corev1.TLSCertKey:       pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		corev1.TLSPrivateKeyKey: keyPEM,
+		CACertKey:               pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw}),
+		caKeyKey:                caKeyPEM,
+	}, nil
+}
+
+func certificateTemplate(commonName string, now time.Time, validity time.Duration) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	return &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(validity),
+		BasicConstraintsValid: true,
+	}, nil
+}
+
+func encodeKey(key crypto.Signer) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+func parseCertificate(data []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("no PEM encoded certificate found")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+func parseKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, fmt.Errorf("no PEM encoded private key found")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+	return signer, nil
+}
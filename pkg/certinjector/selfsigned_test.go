@@ -0,0 +1,213 @@
+package certinjector
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestEnsureSelfSignedSecret(t *testing.T) {
+	injector := newTestClient()
+	secret, err := injector.EnsureSelfSignedSecret("test", "webhook-cert", "validation-webhook")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if _, err := tls.X509KeyPair(secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey]); err != nil {
+		t.Fatalf("Expected a usable key pair, got %s", err.Error())
+	}
+	ca, _ := validCA(secret.Data, time.Now())
+	if ca == nil {
+		t.Fatalf("Expected a valid CA in %v", secret.Data)
+	}
+	if !bytes.Equal(secret.Data[PreviousCACertKey], secret.Data[CACertKey]) {
+		t.Fatalf("Expected a new CA to be its own previous CA")
+	}
+
+	// A valid Secret is left alone
+	again, err := injector.EnsureSelfSignedSecret("test", "webhook-cert", "validation-webhook")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if !bytes.Equal(again.Data[corev1.TLSCertKey], secret.Data[corev1.TLSCertKey]) {
+		t.Fatalf("Expected the certificate not to be reissued")
+	}
+
+	// A certificate for another Service is reissued by the same CA
+	renamed, err := injector.EnsureSelfSignedSecret("test", "webhook-cert", "other-webhook")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if bytes.Equal(renamed.Data[corev1.TLSCertKey], secret.Data[corev1.TLSCertKey]) {
+		t.Fatalf("Expected the certificate to be reissued for other-webhook")
+	}
+	if !bytes.Equal(renamed.Data[CACertKey], secret.Data[CACertKey]) {
+		t.Fatalf("Expected the CA to be kept")
+	}
+	stored, err := injector.clientset.CoreV1().Secrets("test").Get(context.TODO(), "webhook-cert", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if !bytes.Equal(stored.Data[corev1.TLSCertKey], renamed.Data[corev1.TLSCertKey]) {
+		t.Fatalf("Expected the reissued certificate to be stored")
+	}
+
+	dir, err := ioutil.TempDir("", "certs")
+	if err != nil {
+		t.Fatalf("Couldn't create temporary directory: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	if err := WriteCertificates(dir, renamed); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if _, err := os.Stat(filepath.Join(dir, caKeyKey)); !os.IsNotExist(err) {
+		t.Fatalf("Expected the CA's key not to be written out")
+	}
+	if _, err := tls.LoadX509KeyPair(filepath.Join(dir, corev1.TLSCertKey), filepath.Join(dir, corev1.TLSPrivateKeyKey)); err != nil {
+		t.Fatalf("Expected a usable key pair in %s, got %s", dir, err.Error())
+	}
+}
+
+func TestSelfSignedCARenewal(t *testing.T) {
+	// A CA issued long enough ago to be near expiry
+	issued := time.Now().Add(-caValidity + renewBefore/2)
+	dnsNames := SelfSignedDNSNames("validation-webhook", "test")
+	data, err := generateSelfSigned(nil, nil, dnsNames, issued)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	data[PreviousCACertKey] = data[CACertKey]
+	old := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "webhook-cert", Namespace: "test"},
+		Type:       corev1.SecretTypeTLS,
+		Data:       data,
+	}
+	hook := createValidatingWebhookConfiguration("with", "test", map[string]string{
+		InjectFromSecretAnnotation: "test/webhook-cert/" + CACertKey + ",test/webhook-cert/" + PreviousCACertKey,
+	})
+	injector := newTestClient(old, hook)
+
+	renewed, err := injector.EnsureSelfSignedSecret("test", "webhook-cert", "validation-webhook")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if bytes.Equal(renewed.Data[CACertKey], data[CACertKey]) {
+		t.Fatalf("Expected the CA to be replaced")
+	}
+	if !bytes.Equal(renewed.Data[PreviousCACertKey], data[CACertKey]) {
+		t.Fatalf("Expected the replaced CA to be kept as %s", PreviousCACertKey)
+	}
+
+	// Both the old and the reissued serving certificates are trusted by the
+	// injected bundle
+	if err := injector.Inject(); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	webhook, err := injector.clientset.
+		AdmissionregistrationV1().
+		ValidatingWebhookConfigurations().
+		Get(context.TODO(), "with", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	bundle, err := base64.RawStdEncoding.DecodeString(string(webhook.Webhooks[0].ClientConfig.CABundle))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(bundle) {
+		t.Fatalf("Expected certificates in the CA bundle, got %q", bundle)
+	}
+	for name, certs := range map[string]map[string][]byte{"old": data, "reissued": renewed.Data} {
+		cert, err := parseCertificate(certs[corev1.TLSCertKey])
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err.Error())
+		}
+		// Replicas only serve certificates while they are valid
+		at := cert.NotBefore.Add(2 * time.Hour)
+		if _, err := cert.Verify(x509.VerifyOptions{DNSName: dnsNames[2], Roots: roots, CurrentTime: at}); err != nil {
+			t.Fatalf("Expected the %s certificate to be trusted, got %s", name, err.Error())
+		}
+	}
+
+	// Once the replaced CA expires it is dropped
+	if got := previousCA(data[CACertKey], renewed.Data[CACertKey], issued.Add(caValidity+time.Hour)); !bytes.Equal(got, renewed.Data[CACertKey]) {
+		t.Fatalf("Expected an expired previous CA to be replaced by the current one")
+	}
+}
+
+func TestWriteCertificatesLeavesUpToDateFiles(t *testing.T) {
+	injector := newTestClient()
+	secret, err := injector.EnsureSelfSignedSecret("test", "webhook-cert", "validation-webhook")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	dir, err := ioutil.TempDir("", "certs")
+	if err != nil {
+		t.Fatalf("Couldn't create temporary directory: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	if err := WriteCertificates(dir, secret); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	before, err := os.Stat(filepath.Join(dir, corev1.TLSCertKey))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	// Make any rewrite visible in the modification time
+	past := before.ModTime().Add(-time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, corev1.TLSCertKey), past, past); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if err := WriteCertificates(dir, secret); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	after, err := os.Stat(filepath.Join(dir, corev1.TLSCertKey))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if !after.ModTime().Equal(past) {
+		t.Fatalf("Expected an up to date certificate not to be rewritten")
+	}
+}
+
+func TestInjectFromSecret(t *testing.T) {
+	withAnnotation := createValidatingWebhookConfiguration("with", "test", map[string]string{InjectFromSecretAnnotation: "test/webhook-cert"})
+	injector := newTestClient(withAnnotation)
+	if _, err := injector.EnsureSelfSignedSecret("test", "webhook-cert", "validation-webhook"); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if err := injector.Inject(); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	webhook, err := injector.clientset.
+		AdmissionregistrationV1().
+		ValidatingWebhookConfigurations().
+		Get(context.TODO(), "with", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if len(webhook.Webhooks[0].ClientConfig.CABundle) == 0 {
+		t.Fatalf("ValidatingWebhookConfiguration %s, webhook %s missing CA Bundle", webhook.GetName(), webhook.Webhooks[0].Name)
+	}
+}
+
+func TestParseProvisioning(t *testing.T) {
+	for _, s := range []string{"service-ca", "cert-manager", "self-signed"} {
+		if _, err := ParseProvisioning(s); err != nil {
+			t.Fatalf("Unexpected error parsing %s: %s", s, err.Error())
+		}
+	}
+	if _, err := ParseProvisioning("letsencrypt"); err == nil {
+		t.Fatalf("Expected an error parsing an unknown provisioning")
+	}
+}
@@ -0,0 +1,72 @@
+package certinjector
+
+import (
+	"fmt"
+	"strings"
+)
+
+// sourceKind is the kind of object a CA certificate is read from
+type sourceKind string
+
+const (
+	configMapSource sourceKind = "configmap"
+	secretSource    sourceKind = "secret"
+
+	// ServiceCACertKey is the key of the CA certificate in a ConfigMap
+	// populated by the service-ca operator, and the default key for ConfigMap
+	// sources unless Options.ConfigMapKey says otherwise
+	ServiceCACertKey string = "service-ca.crt"
+)
+
+// caSource is where one of the certificates of a CA bundle is read from
+type caSource struct {
+	kind      sourceKind
+	namespace string
+	name      string
+	key       string
+}
+
+func (s caSource) String() string {
+	return fmt.Sprintf("%s %s/%s key %s", s.kind, s.namespace, s.name, s.key)
+}
+
+// parseSources parses the value of an injection annotation: a comma
+// separated list of sources, each of the form
+//
+//	[configmap:|secret:]namespace/name[/key]
+//
+// The kind defaults to defaultKind, and the key to configMapKey for a
+// ConfigMap and ca.crt for a Secret. The certificates of all the sources are
+// concatenated into the CA bundle, so that during a CA rollover both the old
+// and new CA can be trusted.
+func parseSources(value string, defaultKind sourceKind, configMapKey string) ([]caSource, error) {
+	sources := []caSource{}
+	for _, src := range strings.Split(value, ",") {
+		src = strings.TrimSpace(src)
+		source := caSource{kind: defaultKind}
+		if i := strings.Index(src, ":"); i != -1 {
+			source.kind = sourceKind(src[:i])
+			src = src[i+1:]
+		}
+		switch source.kind {
+		case configMapSource:
+			source.key = configMapKey
+		case secretSource:
+			source.key = CACertKey
+		default:
+			return nil, fmt.Errorf("unknown source kind %q, expected %s or %s", source.kind, configMapSource, secretSource)
+		}
+
+		split := strings.Split(src, "/")
+		if len(split) == 3 {
+			source.key = split[2]
+			split = split[:2]
+		}
+		if len(split) != 2 || split[0] == "" || split[1] == "" || source.key == "" {
+			return nil, fmt.Errorf("expected namespace/name[/key], got %q", src)
+		}
+		source.namespace, source.name = split[0], split[1]
+		sources = append(sources, source)
+	}
+	return sources, nil
+}
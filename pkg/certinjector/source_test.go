@@ -0,0 +1,84 @@
+package certinjector
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestParseSources(t *testing.T) {
+	tests := []struct {
+		value       string
+		defaultKind sourceKind
+		expected    []caSource
+		expectedErr bool
+	}{
+		{
+			value:       "test/service-ca",
+			defaultKind: configMapSource,
+			expected:    []caSource{{kind: configMapSource, namespace: "test", name: "service-ca", key: ServiceCACertKey}},
+		},
+		{
+			value:       "test/webhook-cert",
+			defaultKind: secretSource,
+			expected:    []caSource{{kind: secretSource, namespace: "test", name: "webhook-cert", key: CACertKey}},
+		},
+		{
+			value:       "test/cas/new.crt, secret:test/old-ca",
+			defaultKind: configMapSource,
+			expected: []caSource{
+				{kind: configMapSource, namespace: "test", name: "cas", key: "new.crt"},
+				{kind: secretSource, namespace: "test", name: "old-ca", key: CACertKey},
+			},
+		},
+		{
+			value:       "configmap:test/service-ca/ca-bundle.crt",
+			defaultKind: secretSource,
+			expected:    []caSource{{kind: configMapSource, namespace: "test", name: "service-ca", key: "ca-bundle.crt"}},
+		},
+		{value: "service-ca", defaultKind: configMapSource, expectedErr: true},
+		{value: "", defaultKind: configMapSource, expectedErr: true},
+		{value: "test/", defaultKind: configMapSource, expectedErr: true},
+		{value: "test/cas/", defaultKind: configMapSource, expectedErr: true},
+		{value: "test/cas/key/extra", defaultKind: configMapSource, expectedErr: true},
+		{value: "test/cas,", defaultKind: configMapSource, expectedErr: true},
+		{value: "deployment:test/cas", defaultKind: configMapSource, expectedErr: true},
+	}
+	for _, test := range tests {
+		sources, err := parseSources(test.value, test.defaultKind, ServiceCACertKey)
+		if (err != nil) != test.expectedErr {
+			t.Fatalf("%q: expected error=%t, got %v", test.value, test.expectedErr, err)
+		}
+		if !test.expectedErr && !reflect.DeepEqual(sources, test.expected) {
+			t.Fatalf("%q: expected %v, got %v", test.value, test.expected, sources)
+		}
+	}
+}
+
+func TestInjectFromSeveralSources(t *testing.T) {
+	hook := createValidatingWebhookConfiguration("with", "test", map[string]string{InjectFromAnnotation: "test/cas/new.crt,secret:test/old-ca"})
+	cm := createConfigMap("cas", "test", nil, map[string]string{"new.crt": "new-ca\n"})
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "old-ca", Namespace: "test"},
+		Data:       map[string][]byte{CACertKey: []byte("old-ca\n")},
+	}
+	injector := newTestClient(hook, cm, secret)
+	if err := injector.Inject(); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	webhook, err := injector.clientset.
+		AdmissionregistrationV1().
+		ValidatingWebhookConfigurations().
+		Get(context.TODO(), "with", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	expected := injector.pemEncode(strings.Join([]string{"new-ca", "old-ca"}, "\n"))
+	if string(webhook.Webhooks[0].ClientConfig.CABundle) != expected {
+		t.Fatalf("Expected both CAs in the bundle, got %q", string(webhook.Webhooks[0].ClientConfig.CABundle))
+	}
+}
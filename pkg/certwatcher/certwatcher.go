@@ -0,0 +1,177 @@
+package certwatcher
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+
+	"github.com/lisa/k8s-webhook-framework/pkg/metrics"
+)
+
+const (
+	// ServingCertificate labels rotations of the TLS key pair
+	ServingCertificate string = "serving"
+	// CACertificate labels rotations of the CA bundle
+	CACertificate string = "ca"
+)
+
+var log = logf.Log.WithName("certwatcher")
+
+// CertWatcher keeps the webhook server's TLS key pair and CA bundle in sync
+// with the files mounted from the webhook-cert Secret and ConfigMap, so that
+// rotations by the service-ca operator take effect without a restart.
+type CertWatcher struct {
+	mu       sync.RWMutex
+	certPath string
+	keyPath  string
+	caPath   string
+
+	cert   *tls.Certificate
+	caPEM  []byte
+	caPool *x509.CertPool
+}
+
+// New loads the key pair from certPath and keyPath, and the CA bundle from
+// caPath, which may be empty. It is an error if any of them can't be loaded.
+func New(certPath, keyPath, caPath string) (*CertWatcher, error) {
+	c := &CertWatcher{
+		certPath: certPath,
+		keyPath:  keyPath,
+		caPath:   caPath,
+	}
+	if _, err := c.loadKeyPair(); err != nil {
+		return nil, err
+	}
+	if _, err := c.loadCA(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// GetCertificate serves the current key pair; it is meant to be used as
+// tls.Config.GetCertificate.
+func (c *CertWatcher) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cert, nil
+}
+
+// CAPool returns the current CA bundle. It is nil if no CA bundle path was
+// given.
+func (c *CertWatcher) CAPool() *x509.CertPool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.caPool
+}
+
+// loadKeyPair reads the key pair from disk, reporting whether it differs from
+// the one currently served.
+func (c *CertWatcher) loadKeyPair() (bool, error) {
+	cert, err := tls.LoadX509KeyPair(c.certPath, c.keyPath)
+	if err != nil {
+		return false, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	changed := c.cert == nil || !bytes.Equal(c.cert.Certificate[0], cert.Certificate[0])
+	c.cert = &cert
+	return changed, nil
+}
+
+// loadCA reads the CA bundle from disk, reporting whether it differs from the
+// one currently held.
+func (c *CertWatcher) loadCA() (bool, error) {
+	if c.caPath == "" {
+		return false, nil
+	}
+	caPEM, err := ioutil.ReadFile(c.caPath)
+	if err != nil {
+		return false, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return false, fmt.Errorf("no certificates found in %s", c.caPath)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	changed := c.caPEM == nil || !bytes.Equal(c.caPEM, caPEM)
+	c.caPEM = caPEM
+	c.caPool = pool
+	return changed, nil
+}
+
+// reload re-reads everything from disk. Files which fail to load, such as
+// when only half of a key pair has been written, leave the previous contents
+// in place.
+func (c *CertWatcher) reload() {
+	changed, err := c.loadKeyPair()
+	if err != nil {
+		log.Error(err, "Couldn't reload TLS key pair, continuing to serve the previous one", "cert", c.certPath, "key", c.keyPath)
+	} else if changed {
+		log.Info("TLS key pair rotated", "cert", c.certPath, "key", c.keyPath)
+		metrics.RecordCertificateRotation(ServingCertificate)
+	}
+
+	changed, err = c.loadCA()
+	if err != nil {
+		log.Error(err, "Couldn't reload CA bundle, continuing to use the previous one", "cacert", c.caPath)
+	} else if changed {
+		log.Info("CA bundle rotated", "cacert", c.caPath)
+		metrics.RecordCertificateRotation(CACertificate)
+	}
+}
+
+// Start watches the certificate files for changes until stop is closed. The
+// directories containing the files are watched rather than the files
+// themselves because Kubernetes updates mounted Secrets and ConfigMaps by
+// swapping a symlink, which a watch on the old file would miss.
+func (c *CertWatcher) Start(stop <-chan struct{}) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	dirs := map[string]bool{}
+	for _, path := range []string{c.certPath, c.keyPath, c.caPath} {
+		if path == "" {
+			continue
+		}
+		dir := filepath.Dir(path)
+		if dirs[dir] {
+			continue
+		}
+		if err := watcher.Add(dir); err != nil {
+			return err
+		}
+		dirs[dir] = true
+	}
+
+	for {
+		select {
+		case <-stop:
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			// Chmod events are too noisy and never change the contents
+			if event.Op == fsnotify.Chmod {
+				continue
+			}
+			c.reload()
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			log.Error(err, "Error watching certificate files")
+		}
+	}
+}
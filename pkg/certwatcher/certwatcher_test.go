@@ -0,0 +1,133 @@
+package certwatcher
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// createKeyPair returns a PEM encoded self-signed certificate and its key
+func createKeyPair(t *testing.T, commonName string) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Couldn't generate key: %s", err.Error())
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Couldn't create certificate: %s", err.Error())
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Couldn't marshal key: %s", err.Error())
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func writeFile(t *testing.T, path string, contents []byte) {
+	if err := ioutil.WriteFile(path, contents, 0600); err != nil {
+		t.Fatalf("Couldn't write %s: %s", path, err.Error())
+	}
+}
+
+func servedCommonName(t *testing.T, c *CertWatcher) string {
+	cert, err := c.GetCertificate(nil)
+	if err != nil {
+		t.Fatalf("Unexpected error getting certificate: %s", err.Error())
+	}
+	parsed, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatalf("Couldn't parse served certificate: %s", err.Error())
+	}
+	return parsed.Subject.CommonName
+}
+
+func TestNewMissingFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certwatcher")
+	if err != nil {
+		t.Fatalf("Couldn't create temporary directory: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	_, err = New(filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"), "")
+	if err == nil {
+		t.Fatalf("Expected an error loading a missing key pair")
+	}
+}
+
+func TestReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certwatcher")
+	if err != nil {
+		t.Fatalf("Couldn't create temporary directory: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	certPath := filepath.Join(dir, "tls.crt")
+	keyPath := filepath.Join(dir, "tls.key")
+	caPath := filepath.Join(dir, "service-ca.crt")
+
+	cert, key := createKeyPair(t, "first")
+	writeFile(t, certPath, cert)
+	writeFile(t, keyPath, key)
+	writeFile(t, caPath, cert)
+
+	c, err := New(certPath, keyPath, caPath)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if name := servedCommonName(t, c); name != "first" {
+		t.Fatalf("Expected to serve the first certificate, got %s", name)
+	}
+	if c.CAPool() == nil {
+		t.Fatalf("Expected a CA pool to be loaded")
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go c.Start(stop)
+	// give the watch a moment to be established
+	time.Sleep(100 * time.Millisecond)
+
+	cert, key = createKeyPair(t, "second")
+	writeFile(t, keyPath, key)
+	writeFile(t, certPath, cert)
+	writeFile(t, caPath, cert)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for servedCommonName(t, c) != "second" {
+		if time.Now().After(deadline) {
+			t.Fatalf("Rotated certificate was never served")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	c.mu.RLock()
+	caPEM := c.caPEM
+	c.mu.RUnlock()
+	if !bytes.Equal(caPEM, cert) {
+		t.Fatalf("Expected the CA bundle to be reloaded")
+	}
+
+	// A broken key pair leaves the previous one in place
+	writeFile(t, certPath, []byte("not a certificate"))
+	c.reload()
+	if name := servedCommonName(t, c); name != "second" {
+		t.Fatalf("Expected to keep serving the second certificate, got %s", name)
+	}
+}
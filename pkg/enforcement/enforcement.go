@@ -0,0 +1,125 @@
+package enforcement
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+	admissionctl "sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	"github.com/lisa/k8s-webhook-framework/pkg/metrics"
+)
+
+// Mode is what a webhook does with the requests it would deny.
+type Mode string
+
+const (
+	// Enforce denies them. It is the default.
+	Enforce Mode = "enforce"
+	// Warn allows them with a warning to the user.
+	Warn Mode = "warn"
+	// Audit allows them silently; they only show up in logs, metrics and the
+	// audit log.
+	Audit Mode = "audit"
+
+	// ModeAnnotation is the AuditAnnotations key which records the mode of a
+	// request allowed despite the webhook's decision
+	ModeAnnotation string = "enforcement"
+)
+
+var (
+	log = logf.Log.WithName("enforcement")
+
+	mu    sync.RWMutex
+	modes = map[string]Mode{}
+)
+
+// ParseMode checks that s names a Mode.
+func ParseMode(s string) (Mode, error) {
+	switch mode := Mode(s); mode {
+	case Enforce, Warn, Audit:
+		return mode, nil
+	}
+	return "", fmt.Errorf("unknown enforcement mode %q, expected one of %s, %s or %s", s, Enforce, Warn, Audit)
+}
+
+// ParseModes parses a comma-separated list of webhook=mode pairs, eg
+// regular-user-validation=warn,namespace-validation=audit.
+func ParseModes(s string) (map[string]Mode, error) {
+	ret := map[string]Mode{}
+	if s == "" {
+		return ret, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("expected webhook=mode, got %q", pair)
+		}
+		mode, err := ParseMode(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		ret[parts[0]] = mode
+	}
+	return ret, nil
+}
+
+// FormatModes is the inverse of ParseModes.
+func FormatModes(modes map[string]Mode) string {
+	pairs := make([]string, 0, len(modes))
+	for hookName, mode := range modes {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", hookName, mode))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// SetMode sets the mode of hookName.
+func SetMode(hookName string, mode Mode) {
+	mu.Lock()
+	defer mu.Unlock()
+	modes[hookName] = mode
+}
+
+// ModeOf returns the mode of hookName.
+func ModeOf(hookName string) Mode {
+	mu.RLock()
+	defer mu.RUnlock()
+	if mode, ok := modes[hookName]; ok {
+		return mode
+	}
+	return Enforce
+}
+
+// Apply turns resp, hookName's answer to a request, into what should be sent
+// according to hookName's mode, along with any warnings for the user. Only
+// denials are affected; in Warn and Audit mode they become allowed responses
+// which keep the denial's reason, are logged and counted.
+func Apply(hookName string, resp admissionctl.Response) (admissionctl.Response, []string) {
+	mode := ModeOf(hookName)
+	if mode == Enforce || metrics.Decision(resp) != metrics.DecisionDenied {
+		return resp, nil
+	}
+
+	reason := ""
+	if resp.Result != nil {
+		reason = string(resp.Result.Reason)
+	}
+	log.Info("Allowing request the webhook would deny", "webhookName", hookName, "mode", mode, "uid", resp.UID, "reason", reason)
+	metrics.RecordEnforcementOverride(hookName, string(mode))
+
+	ret := admissionctl.Allowed(fmt.Sprintf("Would be denied (%s mode): %s", mode, reason))
+	ret.UID = resp.UID
+	ret.AuditAnnotations = map[string]string{
+		ModeAnnotation: string(mode),
+	}
+	for key, value := range resp.AuditAnnotations {
+		ret.AuditAnnotations[key] = value
+	}
+	if mode == Warn {
+		return ret, []string{fmt.Sprintf("%s: %s", hookName, reason)}
+	}
+	return ret, nil
+}
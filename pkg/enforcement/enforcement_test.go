@@ -0,0 +1,99 @@
+package enforcement
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/types"
+	admissionctl "sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func TestParseModes(t *testing.T) {
+	modes, err := ParseModes("regular-user-validation=warn,namespace-validation=audit,group-validation=enforce")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	expected := map[string]Mode{
+		"regular-user-validation": Warn,
+		"namespace-validation":    Audit,
+		"group-validation":        Enforce,
+	}
+	if !reflect.DeepEqual(modes, expected) {
+		t.Fatalf("Expected %v, got %v", expected, modes)
+	}
+	if formatted := FormatModes(modes); formatted != "group-validation=enforce,namespace-validation=audit,regular-user-validation=warn" {
+		t.Fatalf("Unexpected formatting of %v: %s", modes, formatted)
+	}
+
+	for _, bad := range []string{"regular-user-validation", "=warn", "regular-user-validation=dry-run", "a=warn,,b=audit"} {
+		if _, err := ParseModes(bad); err == nil {
+			t.Fatalf("Expected an error parsing %q", bad)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		mode             Mode
+		resp             admissionctl.Response
+		expectedAllowed  bool
+		expectedWarnings []string
+	}{
+		{
+			mode:            Enforce,
+			resp:            admissionctl.Denied("Denied"),
+			expectedAllowed: false,
+		},
+		{
+			mode:             Warn,
+			resp:             admissionctl.Denied("Denied"),
+			expectedAllowed:  true,
+			expectedWarnings: []string{"test-hook: Denied"},
+		},
+		{
+			mode:            Audit,
+			resp:            admissionctl.Denied("Denied"),
+			expectedAllowed: true,
+		},
+		{
+			mode:            Warn,
+			resp:            admissionctl.Allowed("RBAC allowed"),
+			expectedAllowed: true,
+		},
+		{
+			// Errors are never turned into allowed responses
+			mode:            Audit,
+			resp:            admissionctl.Errored(http.StatusBadRequest, fmt.Errorf("bad request")),
+			expectedAllowed: false,
+		},
+	}
+	defer SetMode("test-hook", Enforce)
+	for _, test := range tests {
+		SetMode("test-hook", test.mode)
+		test.resp.UID = types.UID("test-uid")
+		test.resp.AuditAnnotations = map[string]string{"rule": "test-rule"}
+
+		resp, warnings := Apply("test-hook", test.resp)
+		if resp.Allowed != test.expectedAllowed {
+			t.Fatalf("Expected allowed=%t in %s mode, got %+v", test.expectedAllowed, test.mode, resp)
+		}
+		if !reflect.DeepEqual(warnings, test.expectedWarnings) {
+			t.Fatalf("Expected warnings %v in %s mode, got %v", test.expectedWarnings, test.mode, warnings)
+		}
+		if resp.UID != "test-uid" || resp.AuditAnnotations["rule"] != "test-rule" {
+			t.Fatalf("Expected the UID and rule to be kept in %s mode, got %+v", test.mode, resp)
+		}
+		overridden := !test.resp.Allowed && resp.Allowed
+		if overridden != (resp.AuditAnnotations[ModeAnnotation] == string(test.mode)) {
+			t.Fatalf("Expected the %s mode to be annotated only on overridden responses, got %+v", test.mode, resp)
+		}
+	}
+}
+
+func TestModeOf(t *testing.T) {
+	if mode := ModeOf("unconfigured-hook"); mode != Enforce {
+		t.Fatalf("Expected hooks to be enforced by default, got %s", mode)
+	}
+}
@@ -0,0 +1,78 @@
+package health
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+const (
+	// WebhooksCondition is ready once every registered webhook was constructed
+	WebhooksCondition string = "webhooks"
+	// CertificatesCondition is ready once the TLS serving certificates are
+	// loaded
+	CertificatesCondition string = "certificates"
+	// ShutdownCondition is set to an error when the server begins to shut
+	// down, so that it stops receiving traffic before it stops serving
+	ShutdownCondition string = "shutdown"
+)
+
+// Readiness tracks named conditions which must all be ready before the
+// webhook server may receive admission requests.
+type Readiness struct {
+	mu         sync.RWMutex
+	conditions map[string]error
+}
+
+// NewReadiness creates a Readiness where each of conditions starts out not
+// ready.
+func NewReadiness(conditions ...string) *Readiness {
+	r := &Readiness{
+		conditions: make(map[string]error),
+	}
+	for _, condition := range conditions {
+		r.conditions[condition] = fmt.Errorf("%s not ready yet", condition)
+	}
+	return r
+}
+
+// Set records the state of condition. A nil err marks it ready.
+func (r *Readiness) Set(condition string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.conditions[condition] = err
+}
+
+// Check returns the error of the first (by name) condition not ready, or nil
+// if all are.
+func (r *Readiness) Check() error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.conditions))
+	for name := range r.conditions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := r.conditions[name]; err != nil {
+			return fmt.Errorf("%s: %s", name, err.Error())
+		}
+	}
+	return nil
+}
+
+// ServeHTTP answers readiness probes: 200 when ready, 503 otherwise.
+func (r *Readiness) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if err := r.Check(); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+// Liveness answers liveness probes. If the server can answer at all, it is
+// alive.
+func Liveness(w http.ResponseWriter, req *http.Request) {
+	fmt.Fprintln(w, "ok")
+}
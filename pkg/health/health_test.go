@@ -0,0 +1,48 @@
+package health
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func probe(h http.Handler) int {
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, httptest.NewRequest("GET", "/readyz", nil))
+	return recorder.Code
+}
+
+func TestReadiness(t *testing.T) {
+	r := NewReadiness(WebhooksCondition, CertificatesCondition)
+	if r.Check() == nil {
+		t.Fatalf("Expected a new Readiness not to be ready")
+	}
+	if code := probe(r); code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected %d before any condition is ready, got %d", http.StatusServiceUnavailable, code)
+	}
+
+	r.Set(WebhooksCondition, nil)
+	if code := probe(r); code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected %d with certificates not loaded, got %d", http.StatusServiceUnavailable, code)
+	}
+
+	r.Set(CertificatesCondition, nil)
+	if err := r.Check(); err != nil {
+		t.Fatalf("Expected to be ready, got %s", err.Error())
+	}
+	if code := probe(r); code != http.StatusOK {
+		t.Fatalf("Expected %d with all conditions ready, got %d", http.StatusOK, code)
+	}
+
+	r.Set(CertificatesCondition, fmt.Errorf("certificate expired"))
+	if code := probe(r); code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected %d after a condition failed, got %d", http.StatusServiceUnavailable, code)
+	}
+}
+
+func TestLiveness(t *testing.T) {
+	if code := probe(http.HandlerFunc(Liveness)); code != http.StatusOK {
+		t.Fatalf("Expected liveness to return %d, got %d", http.StatusOK, code)
+	}
+}
@@ -5,19 +5,83 @@ import (
 	"io"
 	"net/http"
 
+	admissionv1 "k8s.io/api/admission/v1"
 	admissionapi "k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	admissionctl "sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 )
 
-// SendResponse Send the AdmissionReview.
-func SendResponse(w io.Writer, resp admissionctl.Response) {
+// admissionReview is an AdmissionReview of either version. Its response
+// embeds the AdmissionResponse so that warnings, which the vendored
+// k8s.io/api predates, can be sent alongside it.
+type admissionReview struct {
+	metav1.TypeMeta `json:",inline"`
+	Response        interface{} `json:"response,omitempty"`
+}
+
+type v1AdmissionResponse struct {
+	*admissionv1.AdmissionResponse
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+type v1beta1AdmissionResponse struct {
+	*admissionapi.AdmissionResponse
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// SendResponse Send the AdmissionReview. The version is the apiVersion of the
+// AdmissionReview being answered (see utils.ParseHTTPRequest); the API server
+// expects the response in the same version it sent. Anything other than
+// admission.k8s.io/v1 is answered with a v1beta1 AdmissionReview.
+// Any JSONPatch operations in resp.Patches are serialized into the response's
+// Patch, with a PatchType of JSONPatch. Any warnings are shown to the user
+// by API servers which support them (1.19 and later), and ignored by others.
+func SendResponse(w io.Writer, resp admissionctl.Response, version string, warnings ...string) {
 
 	encoder := json.NewEncoder(w)
-	responseAdmissionReview := admissionapi.AdmissionReview{
-		Response: &resp.AdmissionResponse,
+	if len(resp.Patches) > 0 {
+		patch, err := json.Marshal(resp.Patches)
+		if err != nil {
+			SendResponse(w, admissionctl.Errored(http.StatusInternalServerError, err), version)
+			return
+		}
+		patchType := admissionapi.PatchTypeJSONPatch
+		resp.Patch = patch
+		resp.PatchType = &patchType
+	}
+	responseAdmissionReview := admissionReview{
+		TypeMeta: metav1.TypeMeta{
+			Kind: "AdmissionReview",
+		},
+	}
+	if version == admissionv1.SchemeGroupVersion.String() {
+		responseAdmissionReview.APIVersion = admissionv1.SchemeGroupVersion.String()
+		responseAdmissionReview.Response = v1AdmissionResponse{
+			AdmissionResponse: v1beta1AdmissionResponseToV1(&resp.AdmissionResponse),
+			Warnings:          warnings,
+		}
+	} else {
+		responseAdmissionReview.APIVersion = admissionapi.SchemeGroupVersion.String()
+		responseAdmissionReview.Response = v1beta1AdmissionResponse{
+			AdmissionResponse: &resp.AdmissionResponse,
+			Warnings:          warnings,
+		}
 	}
 	err := encoder.Encode(responseAdmissionReview)
 	if err != nil {
-		SendResponse(w, admissionctl.Errored(http.StatusInternalServerError, err))
+		SendResponse(w, admissionctl.Errored(http.StatusInternalServerError, err), version)
+	}
+}
+
+// v1beta1AdmissionResponseToV1 copies the v1beta1 AdmissionResponse built by
+// controller-runtime into its admission.k8s.io/v1 equivalent.
+func v1beta1AdmissionResponseToV1(in *admissionapi.AdmissionResponse) *admissionv1.AdmissionResponse {
+	return &admissionv1.AdmissionResponse{
+		UID:              in.UID,
+		Allowed:          in.Allowed,
+		Result:           in.Result,
+		Patch:            in.Patch,
+		PatchType:        (*admissionv1.PatchType)(in.PatchType),
+		AuditAnnotations: in.AuditAnnotations,
 	}
 }
@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"testing"
 
+	admissionv1 "k8s.io/api/admission/v1"
 	admissionapi "k8s.io/api/admission/v1beta1"
 	"k8s.io/apimachinery/pkg/types"
 	admissionctl "sigs.k8s.io/controller-runtime/pkg/webhook/admission"
@@ -40,6 +41,7 @@ func TestResponse(t *testing.T) {
 		uid            string
 		e              error
 		status         int32
+		version        string
 		expectedResult string
 	}{
 		{
@@ -47,21 +49,48 @@ func TestResponse(t *testing.T) {
 			uid:     "test-uid",
 			e:       nil,
 			status:  http.StatusOK,
+			version: admissionapi.SchemeGroupVersion.String(),
 			// the writer sends a newline
-			expectedResult: formatOutput(`{"response":{"uid":"test-uid","allowed":true}}`),
+			expectedResult: formatOutput(`{"kind":"AdmissionReview","apiVersion":"admission.k8s.io/v1beta1","response":{"uid":"test-uid","allowed":true}}`),
 		},
 		{
 			allowed:        false,
 			uid:            "test-fail-with-error",
 			e:              fmt.Errorf("request body is empty"),
 			status:         http.StatusBadRequest,
-			expectedResult: formatOutput(`{"response":{"uid":"","allowed":false,"status":{"metadata":{},"message":"request body is empty","code":400}}}`),
+			version:        admissionapi.SchemeGroupVersion.String(),
+			expectedResult: formatOutput(`{"kind":"AdmissionReview","apiVersion":"admission.k8s.io/v1beta1","response":{"uid":"","allowed":false,"status":{"metadata":{},"message":"request body is empty","code":400}}}`),
+		},
+		{
+			allowed:        true,
+			uid:            "test-v1-uid",
+			e:              nil,
+			status:         http.StatusOK,
+			version:        admissionv1.SchemeGroupVersion.String(),
+			expectedResult: formatOutput(`{"kind":"AdmissionReview","apiVersion":"admission.k8s.io/v1","response":{"uid":"test-v1-uid","allowed":true}}`),
+		},
+		{
+			allowed:        false,
+			uid:            "test-v1-fail-with-error",
+			e:              fmt.Errorf("request body is empty"),
+			status:         http.StatusBadRequest,
+			version:        admissionv1.SchemeGroupVersion.String(),
+			expectedResult: formatOutput(`{"kind":"AdmissionReview","apiVersion":"admission.k8s.io/v1","response":{"uid":"","allowed":false,"status":{"metadata":{},"message":"request body is empty","code":400}}}`),
+		},
+		{
+			// Unknown versions fall back to v1beta1
+			allowed:        true,
+			uid:            "test-unversioned-uid",
+			e:              nil,
+			status:         http.StatusOK,
+			version:        "",
+			expectedResult: formatOutput(`{"kind":"AdmissionReview","apiVersion":"admission.k8s.io/v1beta1","response":{"uid":"test-unversioned-uid","allowed":true}}`),
 		},
 	}
 	for _, test := range tests {
 		buf := makeBuffer()
 		respObj := makeResponseObj(test.uid, test.allowed, test.e)
-		SendResponse(buf, *respObj)
+		SendResponse(buf, *respObj, test.version)
 		if buf.String() != test.expectedResult {
 			t.Fatalf("Expected to have `%s` but got `%s`", test.expectedResult, buf.String())
 		}
@@ -88,3 +117,61 @@ func TestResponse(t *testing.T) {
 	}
 
 }
+
+func TestPatchResponse(t *testing.T) {
+	versions := []string{
+		admissionapi.SchemeGroupVersion.String(),
+		admissionv1.SchemeGroupVersion.String(),
+	}
+	for _, version := range versions {
+		buf := makeBuffer()
+		resp := admissionctl.PatchResponseFromRaw(
+			[]byte(`{"metadata":{"name":"test"}}`),
+			[]byte(`{"metadata":{"name":"test","labels":{"mutated":"true"}}}`))
+		resp.UID = types.UID("test-patch-uid")
+		SendResponse(buf, resp, version)
+
+		decodedResult := &admissionapi.AdmissionReview{}
+		err := json.Unmarshal(buf.Bytes(), decodedResult)
+		if err != nil {
+			t.Fatalf("Couldn't unmarshal the JSON blob: %s", err.Error())
+		}
+		if decodedResult.APIVersion != version {
+			t.Fatalf("Expected a %s response, got %s", version, decodedResult.APIVersion)
+		}
+		if !decodedResult.Response.Allowed {
+			t.Fatalf("Expected the patch response to be allowed")
+		}
+		if decodedResult.Response.PatchType == nil || *decodedResult.Response.PatchType != admissionapi.PatchTypeJSONPatch {
+			t.Fatalf("Expected a JSONPatch PatchType, got %v", decodedResult.Response.PatchType)
+		}
+		expectedPatch := `[{"op":"add","path":"/metadata/labels","value":{"mutated":"true"}}]`
+		if string(decodedResult.Response.Patch) != expectedPatch {
+			t.Fatalf("Expected patch %s, got %s", expectedPatch, string(decodedResult.Response.Patch))
+		}
+	}
+}
+
+func TestWarningsResponse(t *testing.T) {
+	tests := []struct {
+		version        string
+		expectedResult string
+	}{
+		{
+			version:        admissionapi.SchemeGroupVersion.String(),
+			expectedResult: formatOutput(`{"kind":"AdmissionReview","apiVersion":"admission.k8s.io/v1beta1","response":{"uid":"test-warn-uid","allowed":true,"warnings":["first","second"]}}`),
+		},
+		{
+			version:        admissionv1.SchemeGroupVersion.String(),
+			expectedResult: formatOutput(`{"kind":"AdmissionReview","apiVersion":"admission.k8s.io/v1","response":{"uid":"test-warn-uid","allowed":true,"warnings":["first","second"]}}`),
+		},
+	}
+	for _, test := range tests {
+		buf := makeBuffer()
+		respObj := makeResponseObj("test-warn-uid", true, nil)
+		SendResponse(buf, *respObj, test.version, "first", "second")
+		if buf.String() != test.expectedResult {
+			t.Fatalf("Expected to have `%s` but got `%s`", test.expectedResult, buf.String())
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	admissionctl "sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+const (
+	// DecisionAllowed is the decision label for allowed requests
+	DecisionAllowed string = "allowed"
+	// DecisionDenied is the decision label for denied requests
+	DecisionDenied string = "denied"
+	// DecisionErrored is the decision label for requests which could not be
+	// evaluated
+	DecisionErrored string = "errored"
+
+	// ReloadSucceeded is the result label for policy reloads which took effect
+	ReloadSucceeded string = "success"
+	// ReloadFailed is the result label for policy reloads which were rejected
+	ReloadFailed string = "failure"
+)
+
+var (
+	registry = prometheus.NewRegistry()
+
+	admissionResponses = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "webhook_admission_responses_total",
+		Help: "Number of admission responses sent, by webhook, operation, resource and decision.",
+	}, []string{"webhook", "operation", "resource", "decision"})
+
+	admissionDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "webhook_admission_duration_seconds",
+		Help: "Time taken to answer an admission request, by webhook, operation and resource.",
+		// Hooks have a TimeoutSeconds of a few seconds at most
+		Buckets: []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5},
+	}, []string{"webhook", "operation", "resource"})
+
+	decodeFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "webhook_decode_failures_total",
+		Help: "Number of requests whose AdmissionReview could not be decoded, by webhook.",
+	}, []string{"webhook"})
+
+	certificateRotations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "webhook_certificate_rotations_total",
+		Help: "Number of times a TLS certificate was reloaded from disk with new contents, by certificate.",
+	}, []string{"certificate"})
+
+	policyReloads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "webhook_policy_reloads_total",
+		Help: "Number of times the policy file changed on disk, by whether the new policy took effect.",
+	}, []string{"result"})
+
+	enforcementOverrides = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "webhook_enforcement_overrides_total",
+		Help: "Number of requests allowed although the webhook would have denied them, by webhook and enforcement mode.",
+	}, []string{"webhook", "mode"})
+
+	misroutedRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "webhook_misrouted_requests_total",
+		Help: "Number of requests received by a webhook whose rules don't match them, by webhook.",
+	}, []string{"webhook"})
+)
+
+// Decision classifies resp as one of DecisionAllowed, DecisionDenied or
+// DecisionErrored. admissionctl.Denied responses carry a 403 code, whereas
+// admissionctl.Errored responses carry whatever code the hook chose.
+func Decision(resp admissionctl.Response) string {
+	if resp.Allowed {
+		return DecisionAllowed
+	}
+	if resp.Result != nil && resp.Result.Code == http.StatusForbidden {
+		return DecisionDenied
+	}
+	return DecisionErrored
+}
+
+// RecordResponse records that hookName answered req with resp, having started
+// work on it at start.
+func RecordResponse(hookName string, req admissionctl.Request, resp admissionctl.Response, start time.Time) {
+	operation := string(req.Operation)
+	groupResource := metav1.GroupResource{
+		Group:    req.Resource.Group,
+		Resource: req.Resource.Resource,
+	}
+	resource := groupResource.String()
+
+	admissionResponses.WithLabelValues(hookName, operation, resource, Decision(resp)).Inc()
+	admissionDuration.WithLabelValues(hookName, operation, resource).Observe(time.Since(start).Seconds())
+}
+
+// RecordDecodeFailure records that a request sent to hookName could not be
+// decoded by utils.ParseHTTPRequest.
+func RecordDecodeFailure(hookName string) {
+	decodeFailures.WithLabelValues(hookName).Inc()
+}
+
+// RecordCertificateRotation records that the certificate (eg, serving or ca)
+// was reloaded with new contents.
+func RecordCertificateRotation(certificate string) {
+	certificateRotations.WithLabelValues(certificate).Inc()
+}
+
+// RecordPolicyReload records an attempt to reload the policy file, which err
+// says was rejected.
+func RecordPolicyReload(err error) {
+	result := ReloadSucceeded
+	if err != nil {
+		result = ReloadFailed
+	}
+	policyReloads.WithLabelValues(result).Inc()
+}
+
+// RecordEnforcementOverride records that a request hookName would have denied
+// was allowed because of its enforcement mode (eg, warn or audit).
+func RecordEnforcementOverride(hookName, mode string) {
+	enforcementOverrides.WithLabelValues(hookName, mode).Inc()
+}
+
+// RecordMisroutedRequest records that hookName was sent a request its rules
+// don't match.
+func RecordMisroutedRequest(hookName string) {
+	misroutedRequests.WithLabelValues(hookName).Inc()
+}
+
+// Handler serves the collected metrics in the Prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+func init() {
+	registry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		admissionResponses,
+		admissionDuration,
+		decodeFailures,
+		certificateRotations,
+		policyReloads,
+		enforcementOverrides,
+		misroutedRequests,
+	)
+}
@@ -0,0 +1,91 @@
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	admissionctl "sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func TestDecision(t *testing.T) {
+	tests := []struct {
+		resp             admissionctl.Response
+		expectedDecision string
+	}{
+		{
+			resp:             admissionctl.Allowed("ok"),
+			expectedDecision: DecisionAllowed,
+		},
+		{
+			resp:             admissionctl.Denied("no"),
+			expectedDecision: DecisionDenied,
+		},
+		{
+			resp:             admissionctl.Errored(http.StatusBadRequest, fmt.Errorf("bad")),
+			expectedDecision: DecisionErrored,
+		},
+	}
+	for _, test := range tests {
+		if got := Decision(test.resp); got != test.expectedDecision {
+			t.Fatalf("Expected decision %s, got %s for %+v", test.expectedDecision, got, test.resp)
+		}
+	}
+}
+
+func TestRecordResponse(t *testing.T) {
+	req := admissionctl.Request{
+		AdmissionRequest: v1beta1.AdmissionRequest{
+			Operation: v1beta1.Update,
+			Resource: metav1.GroupVersionResource{
+				Group:    "user.openshift.io",
+				Version:  "v1",
+				Resource: "groups",
+			},
+		},
+	}
+	RecordResponse("test-hook", req, admissionctl.Denied("no"), time.Now())
+	RecordResponse("test-hook", req, admissionctl.Denied("no"), time.Now())
+	RecordResponse("test-hook", req, admissionctl.Allowed("ok"), time.Now())
+
+	denied := testutil.ToFloat64(admissionResponses.WithLabelValues("test-hook", "UPDATE", "groups.user.openshift.io", DecisionDenied))
+	if denied != 2 {
+		t.Fatalf("Expected 2 denied responses, got %f", denied)
+	}
+	allowed := testutil.ToFloat64(admissionResponses.WithLabelValues("test-hook", "UPDATE", "groups.user.openshift.io", DecisionAllowed))
+	if allowed != 1 {
+		t.Fatalf("Expected 1 allowed response, got %f", allowed)
+	}
+}
+
+func TestRecordPolicyReload(t *testing.T) {
+	RecordPolicyReload(nil)
+	RecordPolicyReload(fmt.Errorf("bad policy"))
+	RecordPolicyReload(fmt.Errorf("bad policy"))
+
+	if got := testutil.ToFloat64(policyReloads.WithLabelValues(ReloadSucceeded)); got != 1 {
+		t.Fatalf("Expected 1 successful reload, got %f", got)
+	}
+	if got := testutil.ToFloat64(policyReloads.WithLabelValues(ReloadFailed)); got != 2 {
+		t.Fatalf("Expected 2 failed reloads, got %f", got)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	RecordDecodeFailure("test-decode-hook")
+	recorder := httptest.NewRecorder()
+	Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected a 200 from the metrics handler, got %d", recorder.Code)
+	}
+	expected := `webhook_decode_failures_total{webhook="test-decode-hook"} 1`
+	if !strings.Contains(recorder.Body.String(), expected) {
+		t.Fatalf("Expected metrics output to contain %s, got:\n%s", expected, recorder.Body.String())
+	}
+}
@@ -0,0 +1,224 @@
+package policy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sync/atomic"
+
+	"github.com/ghodss/yaml"
+	"github.com/lisa/k8s-webhook-framework/pkg/authz"
+	"github.com/lisa/k8s-webhook-framework/pkg/webhooks/utils"
+)
+
+// Policy holds the users, groups and namespaces which the webhooks treat as
+// privileged. Fields left out of a policy file keep their Default values.
+type Policy struct {
+	// ClusterAdminUsers may do anything
+	ClusterAdminUsers []string `json:"clusterAdminUsers"`
+	// SREAdminGroups may access privileged namespaces
+	SREAdminGroups []string `json:"sreAdminGroups"`
+	// GroupAdminGroups may change protected groups. The group hook has
+	// always compared against one comma-joined entry, which no real group
+	// matches, so by default only cluster admins may. List the SRE admin
+	// groups separately to let them too.
+	GroupAdminGroups []string `json:"groupAdminGroups"`
+	// PrivilegedUsers may manage Identities of the SRE identity provider
+	PrivilegedUsers []string `json:"privilegedUsers"`
+	// LayeredProductAdminGroups may access layered product namespaces
+	LayeredProductAdminGroups []string `json:"layeredProductAdminGroups"`
+	// OLMNamespaces are privileged namespaces where dedicated admins may
+	// still create Subscriptions
+	OLMNamespaces []string `json:"olmNamespaces"`
+
+	// PrivilegedNamespaces is a regular expression matching the names of
+	// namespaces which belong to the platform rather than the customer
+	PrivilegedNamespaces string `json:"privilegedNamespaces"`
+	// LayeredProductNamespaces is a regular expression matching the names of
+	// namespaces which belong to layered products
+	LayeredProductNamespaces string `json:"layeredProductNamespaces"`
+	// PrivilegedServiceAccounts is a regular expression matching the groups
+	// of service accounts which may access privileged namespaces
+	PrivilegedServiceAccounts string `json:"privilegedServiceAccounts"`
+	// ProtectedGroups is a regular expression matching the names of Groups
+	// which only admins may change
+	ProtectedGroups string `json:"protectedGroups"`
+
+	privilegedNamespacesRe      *regexp.Regexp
+	layeredProductNamespacesRe  *regexp.Regexp
+	privilegedServiceAccountsRe *regexp.Regexp
+	protectedGroupsRe           *regexp.Regexp
+}
+
+var current atomic.Value
+
+func init() {
+	current.Store(Default())
+}
+
+// Default returns the policy used when no policy file is given.
+func Default() *Policy {
+	p, err := defaults().compile()
+	if err != nil {
+		panic(err.Error())
+	}
+	return p
+}
+
+func defaults() *Policy {
+	return &Policy{
+		ClusterAdminUsers:         []string{"kube:admin", "system:admin"},
+		SREAdminGroups:            []string{"osd-sre-admins", "osd-sre-cluster-admins"},
+		GroupAdminGroups:          []string{"osd-sre-admins,osd-sre-cluster-admins"},
+		PrivilegedUsers:           []string{"kube:admin", "system:admin", "system:serviceaccount:openshift-authentication:oauth-openshift"},
+		LayeredProductAdminGroups: []string{"layered-sre-cluster-admins"},
+		OLMNamespaces:             []string{"openshift-marketplace", "openshift-operators"},
+		PrivilegedNamespaces:      `(^kube.*|^openshift.*|^default$|^redhat.*)`,
+		LayeredProductNamespaces:  `^redhat.*`,
+		PrivilegedServiceAccounts: `^system:serviceaccounts:(kube.*|openshift.*|default|redhat.*)`,
+		ProtectedGroups:           `(^osd-sre.*|^dedicated-admins$|^cluster-admins$|^layered-cs-sre-admins$)`,
+	}
+}
+
+// Current returns the policy in effect. Callers should hold on to the result
+// for the duration of a request so that a reload can't change the rules
+// halfway through a decision.
+func Current() *Policy {
+	return current.Load().(*Policy)
+}
+
+// Set replaces the policy in effect.
+func Set(p *Policy) {
+	current.Store(p)
+}
+
+// Parse reads a policy from YAML or JSON. Unknown fields, empty list entries
+// and regular expressions which are empty or don't compile are errors, so
+// that a typo can't silently widen or narrow the policy. So is an empty
+// document, which is more likely a truncated file than a request for the
+// defaults; use {} for those.
+func Parse(data []byte) (*Policy, error) {
+	raw, err := yaml.YAMLToJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(bytes.TrimSpace(raw), []byte("null")) {
+		return nil, fmt.Errorf("policy is empty")
+	}
+	p := defaults()
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(p); err != nil {
+		return nil, err
+	}
+	return p.compile()
+}
+
+// Load reads a policy from the file at path. See Parse.
+func Load(path string) (*Policy, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	p, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid policy in %s: %s", path, err.Error())
+	}
+	return p, nil
+}
+
+// compile validates p and compiles its regular expressions
+func (p *Policy) compile() (*Policy, error) {
+	lists := map[string][]string{
+		"clusterAdminUsers":         p.ClusterAdminUsers,
+		"sreAdminGroups":            p.SREAdminGroups,
+		"groupAdminGroups":          p.GroupAdminGroups,
+		"privilegedUsers":           p.PrivilegedUsers,
+		"layeredProductAdminGroups": p.LayeredProductAdminGroups,
+		"olmNamespaces":             p.OLMNamespaces,
+	}
+	for field, list := range lists {
+		for _, entry := range list {
+			if entry == "" {
+				return nil, fmt.Errorf("%s may not contain empty entries", field)
+			}
+		}
+	}
+
+	var err error
+	regexes := []struct {
+		field string
+		expr  string
+		re    **regexp.Regexp
+	}{
+		{"privilegedNamespaces", p.PrivilegedNamespaces, &p.privilegedNamespacesRe},
+		{"layeredProductNamespaces", p.LayeredProductNamespaces, &p.layeredProductNamespacesRe},
+		{"privilegedServiceAccounts", p.PrivilegedServiceAccounts, &p.privilegedServiceAccountsRe},
+		{"protectedGroups", p.ProtectedGroups, &p.protectedGroupsRe},
+	}
+	for _, r := range regexes {
+		// An empty expression matches everything
+		if r.expr == "" {
+			return nil, fmt.Errorf("%s may not be empty", r.field)
+		}
+		if *r.re, err = regexp.Compile(r.expr); err != nil {
+			return nil, fmt.Errorf("%s: %s", r.field, err.Error())
+		}
+	}
+	return p, nil
+}
+
+// ClusterAdmin matches cluster admins, who may do anything
+func (p *Policy) ClusterAdmin() authz.Predicate {
+	return authz.UserIn(p.ClusterAdminUsers...)
+}
+
+// SREAdmin matches users in one of the SRE admin groups
+func (p *Policy) SREAdmin() authz.Predicate {
+	return authz.InGroup(p.SREAdminGroups...)
+}
+
+// GroupAdmin matches users in one of the groups which may change protected
+// groups
+func (p *Policy) GroupAdmin() authz.Predicate {
+	return authz.InGroup(p.GroupAdminGroups...)
+}
+
+// PrivilegedUser matches users who may manage SRE Identities
+func (p *Policy) PrivilegedUser() authz.Predicate {
+	return authz.UserIn(p.PrivilegedUsers...)
+}
+
+// LayeredProductAdmin matches users in one of the layered product admin
+// groups
+func (p *Policy) LayeredProductAdmin() authz.Predicate {
+	return authz.InGroup(p.LayeredProductAdminGroups...)
+}
+
+// IsOLMNamespace Is the namespace one where OLM expects Subscriptions?
+func (p *Policy) IsOLMNamespace(name string) bool {
+	return utils.SliceContains(name, p.OLMNamespaces)
+}
+
+// IsPrivilegedNamespace Is the namespace a privileged one?
+func (p *Policy) IsPrivilegedNamespace(name string) bool {
+	return p.privilegedNamespacesRe.MatchString(name)
+}
+
+// IsLayeredProductNamespace Does the namespace belong to a layered product?
+func (p *Policy) IsLayeredProductNamespace(name string) bool {
+	return p.layeredProductNamespacesRe.MatchString(name)
+}
+
+// PrivilegedServiceAccount matches privileged service accounts. Service
+// accounts are identified by the groups they are in.
+func (p *Policy) PrivilegedServiceAccount() authz.Predicate {
+	return authz.GroupMatches(p.privilegedServiceAccountsRe)
+}
+
+// IsProtectedGroup Is the Group one which only admins may change?
+func (p *Policy) IsProtectedGroup(name string) bool {
+	return p.protectedGroupsRe.MatchString(name)
+}
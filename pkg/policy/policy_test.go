@@ -0,0 +1,140 @@
+package policy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+)
+
+// user returns the UserInfo of username in groups
+func user(username string, groups ...string) authenticationv1.UserInfo {
+	return authenticationv1.UserInfo{Username: username, Groups: groups}
+}
+
+func TestIsPrivilegedNamespace(t *testing.T) {
+	tests := []struct {
+		namespace      string
+		expectedResult bool
+	}{
+		{namespace: "kube-system", expectedResult: true},
+		{namespace: "openshift-marketplace", expectedResult: true},
+		{namespace: "redhat-layered-product", expectedResult: true},
+		{namespace: "default", expectedResult: true},
+		{namespace: "default-customer", expectedResult: false},
+		{namespace: "my-ns", expectedResult: false},
+	}
+
+	p := Default()
+	for _, test := range tests {
+		if p.IsPrivilegedNamespace(test.namespace) != test.expectedResult {
+			t.Fatalf("expected %t, got %t for namespace %s", test.expectedResult, p.IsPrivilegedNamespace(test.namespace), test.namespace)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	if _, err := Parse([]byte("{}")); err != nil {
+		t.Fatalf("Unexpected error parsing an all default policy: %s", err.Error())
+	}
+
+	p, err := Parse([]byte(`
+clusterAdminUsers:
+  - fleet:admin
+privilegedNamespaces: ^fleet-.*
+`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if !p.ClusterAdmin()(user("fleet:admin")) || p.ClusterAdmin()(user("kube:admin")) {
+		t.Fatalf("Expected clusterAdminUsers to be replaced, got %v", p.ClusterAdminUsers)
+	}
+	if !p.IsPrivilegedNamespace("fleet-system") || p.IsPrivilegedNamespace("openshift-monitoring") {
+		t.Fatalf("Expected privilegedNamespaces to be replaced, got %s", p.PrivilegedNamespaces)
+	}
+	// Everything else is defaulted
+	if !p.SREAdmin()(user("test-user", "system:authenticated", "osd-sre-admins")) {
+		t.Fatalf("Expected the default sreAdminGroups, got %v", p.SREAdminGroups)
+	}
+	if p.GroupAdmin()(user("test-user", "system:authenticated", "osd-sre-admins")) {
+		t.Fatalf("Expected the default groupAdminGroups, got %v", p.GroupAdminGroups)
+	}
+	if !p.IsProtectedGroup("dedicated-admins") {
+		t.Fatalf("Expected the default protectedGroups, got %s", p.ProtectedGroups)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy string
+	}{
+		{name: "unknown field", policy: "clusterAdminUser: [fleet:admin]"},
+		{name: "wrong type", policy: "sreAdminGroups: osd-sre-admins"},
+		{name: "empty entry", policy: `sreAdminGroups: ["osd-sre-admins", ""]`},
+		{name: "empty regex", policy: `protectedGroups: ""`},
+		{name: "bad regex", policy: "privilegedNamespaces: (^kube.*"},
+		{name: "not yaml", policy: "clusterAdminUsers: [kube:admin"},
+		{name: "empty document", policy: ""},
+		{name: "null document", policy: "# all defaults\n"},
+	}
+	for _, test := range tests {
+		if _, err := Parse([]byte(test.policy)); err == nil {
+			t.Fatalf("Expected an error parsing policy with %s: %s", test.name, test.policy)
+		}
+	}
+}
+
+func TestWatcher(t *testing.T) {
+	defer Set(Default())
+	dir, err := ioutil.TempDir("", "policy")
+	if err != nil {
+		t.Fatalf("Couldn't create temporary directory: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "policy.yaml")
+
+	if _, err := NewWatcher(path); err == nil {
+		t.Fatalf("Expected an error loading a missing policy")
+	}
+
+	writeFile(t, path, "clusterAdminUsers: [first:admin]")
+	w, err := NewWatcher(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if !Current().ClusterAdmin()(user("first:admin")) {
+		t.Fatalf("Expected the policy to be in effect, got %v", Current().ClusterAdminUsers)
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go w.Start(stop)
+	// give the watch a moment to be established
+	time.Sleep(100 * time.Millisecond)
+
+	writeFile(t, path, "clusterAdminUsers: [second:admin]")
+	deadline := time.Now().Add(5 * time.Second)
+	for !Current().ClusterAdmin()(user("second:admin")) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Updated policy never took effect")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	// An invalid policy leaves the previous one in effect
+	writeFile(t, path, "clusterAdminUsers: second:admin")
+	w.reload()
+	if !Current().ClusterAdmin()(user("second:admin")) {
+		t.Fatalf("Expected to keep the previous policy, got %v", Current().ClusterAdminUsers)
+	}
+}
+
+func writeFile(t *testing.T, path, contents string) {
+	if err := ioutil.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatalf("Couldn't write %s: %s", path, err.Error())
+	}
+}
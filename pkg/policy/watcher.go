@@ -0,0 +1,102 @@
+package policy
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+
+	"github.com/lisa/k8s-webhook-framework/pkg/metrics"
+)
+
+var log = logf.Log.WithName("policy")
+
+// Watcher keeps the policy in effect in sync with a policy file, typically
+// mounted from a ConfigMap.
+type Watcher struct {
+	mu   sync.Mutex
+	path string
+	// data is the content of the policy in effect
+	data []byte
+}
+
+// NewWatcher loads the policy in path and puts it into effect. It is an error
+// if the policy can't be loaded.
+func NewWatcher(path string) (*Watcher, error) {
+	w := &Watcher{path: path}
+	if _, err := w.load(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// load reads the policy from disk and puts it into effect, reporting whether
+// it differs from the one previously in effect. A policy which fails to load
+// leaves the previous one in effect.
+func (w *Watcher) load() (bool, error) {
+	data, err := ioutil.ReadFile(w.path)
+	if err != nil {
+		return false, err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.data != nil && bytes.Equal(w.data, data) {
+		return false, nil
+	}
+	p, err := Parse(data)
+	if err != nil {
+		return false, err
+	}
+	w.data = data
+	Set(p)
+	return true, nil
+}
+
+func (w *Watcher) reload() {
+	changed, err := w.load()
+	if err != nil {
+		log.Error(err, "Couldn't reload policy, continuing to use the previous one", "path", w.path)
+		metrics.RecordPolicyReload(err)
+	} else if changed {
+		log.Info("Policy reloaded", "path", w.path)
+		metrics.RecordPolicyReload(nil)
+	}
+}
+
+// Start watches the policy file for changes until stop is closed. As with
+// certificates, the containing directory is watched so that ConfigMap updates,
+// which swap a symlink, are noticed.
+func (w *Watcher) Start(stop <-chan struct{}) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+	if err := watcher.Add(filepath.Dir(w.path)); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-stop:
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			// Chmod events are too noisy and never change the contents
+			if event.Op == fsnotify.Chmod {
+				continue
+			}
+			w.reload()
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			log.Error(err, "Error watching policy file")
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package testutils
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"k8s.io/api/admission/v1beta1"
+)
+
+const (
+	// fixtureExt is the extension of a captured AdmissionReview
+	fixtureExt string = ".json"
+	// goldenExt is the extension of the expected response that sits next to
+	// each fixture: foo.json is checked against foo.golden
+	goldenExt string = ".golden"
+	// updateEnv is the environment variable which, set to 1, makes
+	// RunFixtures rewrite the .golden files rather than check them. It isn't a
+	// flag so that importing testutils doesn't add one to every binary.
+	updateEnv string = "UPDATE_GOLDEN"
+)
+
+// GoldenResponse is the part of a webhook's AdmissionResponse that is
+// recorded in a .golden file.
+type GoldenResponse struct {
+	Allowed bool  `json:"allowed"`
+	Code    int32 `json:"code,omitempty"`
+	// Message is the Status message, or its reason when there is no message,
+	// which is what Allowed and Denied responses set.
+	Message string `json:"message,omitempty"`
+}
+
+func newGoldenResponse(resp *v1beta1.AdmissionResponse) GoldenResponse {
+	ret := GoldenResponse{Allowed: resp.Allowed}
+	if resp.Result != nil {
+		ret.Code = resp.Result.Code
+		ret.Message = resp.Result.Message
+		if ret.Message == "" {
+			ret.Message = string(resp.Result.Reason)
+		}
+	}
+	return ret
+}
+
+func (g GoldenResponse) marshal() ([]byte, error) {
+	b, err := json.MarshalIndent(g, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+// RunFixtures replays every AdmissionReview in dir (*.json) through s with
+// SendHTTPRequest and compares the response with the .golden file of the same
+// name, eg fixtures/namespaces/create-sre-ns.json is checked against
+// fixtures/namespaces/create-sre-ns.golden. Each fixture runs as its own
+// subtest and a mismatch is reported as a diff.
+//
+// To add a regression case, save the AdmissionReview (eg from an audit log)
+// into dir, run the hook's tests with UPDATE_GOLDEN=1 to write its .golden
+// file and check the recorded verdict before committing both.
+func RunFixtures(t *testing.T, s Webhook, dir string) {
+	fixtures, err := filepath.Glob(filepath.Join(dir, "*"+fixtureExt))
+	if err != nil {
+		t.Fatalf("Couldn't list fixtures in %s: %s", dir, err.Error())
+	}
+	if len(fixtures) == 0 {
+		t.Fatalf("No fixtures found in %s", dir)
+	}
+	for _, fixture := range fixtures {
+		fixture := fixture
+		name := strings.TrimSuffix(filepath.Base(fixture), fixtureExt)
+		t.Run(name, func(t *testing.T) {
+			runFixture(t, s, fixture)
+		})
+	}
+}
+
+func runFixture(t *testing.T, s Webhook, fixture string) {
+	body, err := ioutil.ReadFile(fixture)
+	if err != nil {
+		t.Fatalf("Couldn't read fixture %s: %s", fixture, err.Error())
+	}
+	httprequest := httptest.NewRequest("POST", s.GetURI(), bytes.NewBuffer(body))
+	httprequest.Header["Content-Type"] = []string{"application/json"}
+
+	response, err := SendHTTPRequest(httprequest, s)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err.Error())
+	}
+	if response == nil {
+		t.Fatalf("No response to %s", fixture)
+	}
+	// The API server rejects admission.k8s.io/v1 responses whose UID isn't
+	// the request's
+	review := &v1beta1.AdmissionReview{}
+	if err := json.Unmarshal(body, review); err != nil {
+		t.Fatalf("Couldn't parse fixture %s: %s", fixture, err.Error())
+	}
+	if review.Request == nil || response.UID != review.Request.UID {
+		t.Fatalf("Response to %s has UID %q, not the request's", fixture, response.UID)
+	}
+	got, err := newGoldenResponse(response).marshal()
+	if err != nil {
+		t.Fatalf("Couldn't marshal response: %s", err.Error())
+	}
+
+	golden := strings.TrimSuffix(fixture, fixtureExt) + goldenExt
+	if os.Getenv(updateEnv) == "1" {
+		if err := ioutil.WriteFile(golden, got, 0644); err != nil {
+			t.Fatalf("Couldn't write %s: %s", golden, err.Error())
+		}
+		return
+	}
+	raw, err := ioutil.ReadFile(golden)
+	if os.IsNotExist(err) {
+		t.Fatalf("No expected response for %s; run the tests with %s=1 to create %s", fixture, updateEnv, golden)
+	}
+	if err != nil {
+		t.Fatalf("Couldn't read %s: %s", golden, err.Error())
+	}
+	// Round trip the golden file so that only differences in content, not in
+	// formatting, are reported
+	expected := GoldenResponse{}
+	if err := json.Unmarshal(raw, &expected); err != nil {
+		t.Fatalf("Couldn't parse %s: %s", golden, err.Error())
+	}
+	want, err := expected.marshal()
+	if err != nil {
+		t.Fatalf("Couldn't marshal %s: %s", golden, err.Error())
+	}
+	if !bytes.Equal(want, got) {
+		t.Errorf("Response to %s doesn't match %s (-want +got):\n%s", fixture, golden, diffLines(string(want), string(got)))
+	}
+}
+
+// diffLines renders a line based diff of want and got, prefixing lines only in
+// want with "-", lines only in got with "+" and common lines with " ".
+func diffLines(want, got string) string {
+	a := strings.Split(strings.TrimSuffix(want, "\n"), "\n")
+	b := strings.Split(strings.TrimSuffix(got, "\n"), "\n")
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var out strings.Builder
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			fmt.Fprintf(&out, " %s\n", a[i])
+			i++
+			j++
+		case j < len(b) && (i == len(a) || lcs[i][j+1] > lcs[i+1][j]):
+			fmt.Fprintf(&out, "+%s\n", b[j])
+			j++
+		default:
+			fmt.Fprintf(&out, "-%s\n", a[i])
+			i++
+		}
+	}
+	return out.String()
+}
@@ -0,0 +1,40 @@
+package testutils
+
+import (
+	"testing"
+)
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		want     string
+		got      string
+		expected string
+	}{
+		{
+			want:     "a\nb\nc\n",
+			got:      "a\nb\nc\n",
+			expected: " a\n b\n c\n",
+		},
+		{
+			want:     "{\n  \"allowed\": true\n}\n",
+			got:      "{\n  \"allowed\": false\n}\n",
+			expected: " {\n-  \"allowed\": true\n+  \"allowed\": false\n }\n",
+		},
+		{
+			want:     "a\nc\n",
+			got:      "a\nb\nc\n",
+			expected: " a\n+b\n c\n",
+		},
+		{
+			want:     "a\nb\nc\n",
+			got:      "a\nc\n",
+			expected: " a\n-b\n c\n",
+		},
+	}
+	for _, test := range tests {
+		diff := diffLines(test.want, test.got)
+		if diff != test.expected {
+			t.Fatalf("Mismatch: diff of %q and %q is\n%s\nexpected\n%s", test.want, test.got, diff, test.expected)
+		}
+	}
+}
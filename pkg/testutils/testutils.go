@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"testing"
 
 	"k8s.io/api/admission/v1beta1"
 	authenticationv1 "k8s.io/api/authentication/v1"
@@ -113,3 +114,29 @@ func SendHTTPRequest(req *http.Request, s Webhook) (*v1beta1.AdmissionResponse,
 	}
 	return ret.Response, nil
 }
+
+// BenchmarkWebhook measures how quickly s handles the AdmissionReview in body
+// (see CreateFakeRequestJSON), both one request at a time ("serial") and from
+// GOMAXPROCS goroutines at once ("parallel"). A parallel ns/op well below the
+// serial one shows that requests are handled concurrently.
+func BenchmarkWebhook(b *testing.B, s Webhook, body []byte) {
+	send := func() {
+		req := httptest.NewRequest("POST", s.GetURI(), bytes.NewReader(body))
+		req.Header["Content-Type"] = []string{"application/json"}
+		s.HandleRequest(httptest.NewRecorder(), req)
+	}
+	b.Run("serial", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			send()
+		}
+	})
+	b.Run("parallel", func(b *testing.B) {
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				send()
+			}
+		})
+	})
+}
@@ -0,0 +1,27 @@
+package webhooks
+
+import (
+	"fmt"
+
+	"github.com/lisa/k8s-webhook-framework/pkg/webhooks/celpolicy"
+)
+
+// RegisterCELPolicies registers a webhook for each CEL policy in the file at
+// path. Unlike the built-in hooks, they are only known once the file is
+// read, so this must be called before Webhooks is used.
+func RegisterCELPolicies(path string) error {
+	policies, err := celpolicy.Load(path)
+	if err != nil {
+		return err
+	}
+	for _, p := range policies {
+		if _, ok := Webhooks[p.Name]; ok {
+			return fmt.Errorf("CEL policy %s clashes with an existing webhook", p.Name)
+		}
+	}
+	for _, p := range policies {
+		p := p
+		Register(p.Name, func() Webhook { return celpolicy.NewWebhook(p) })
+	}
+	return nil
+}
@@ -0,0 +1,23 @@
+package webhooks
+
+import (
+	"fmt"
+
+	"github.com/lisa/k8s-webhook-framework/pkg/webhooks/regopolicy"
+)
+
+// RegisterRegoPolicy registers a webhook evaluating the Rego modules in dir.
+// Unlike the built-in hooks, it is only known once the directory is read, so
+// this must be called before Webhooks is used. Every use of Webhooks gets
+// the returned webhook, whose Start reloads the modules when they change.
+func RegisterRegoPolicy(dir string) (*regopolicy.RegoWebhook, error) {
+	hook, err := regopolicy.NewWebhook(dir)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := Webhooks[hook.Name()]; ok {
+		return nil, fmt.Errorf("Rego policy %s clashes with an existing webhook", hook.Name())
+	}
+	Register(hook.Name(), func() Webhook { return hook })
+	return hook, nil
+}
@@ -0,0 +1,9 @@
+package webhooks
+
+import (
+	"github.com/lisa/k8s-webhook-framework/pkg/webhooks/subscription"
+)
+
+func init() {
+	Register(subscription.WebhookName, func() Webhook { return subscription.NewWebhook() })
+}
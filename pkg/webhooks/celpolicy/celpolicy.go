@@ -0,0 +1,168 @@
+package celpolicy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/lisa/k8s-webhook-framework/pkg/audit"
+	"github.com/lisa/k8s-webhook-framework/pkg/enforcement"
+	responsehelper "github.com/lisa/k8s-webhook-framework/pkg/helpers"
+	"github.com/lisa/k8s-webhook-framework/pkg/metrics"
+	"github.com/lisa/k8s-webhook-framework/pkg/webhooks/utils"
+	admissionregv1 "k8s.io/api/admissionregistration/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+	admissionctl "sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+var (
+	log = logf.Log.WithName("cel-policy")
+
+	sideEffects = admissionregv1.SideEffectClassNone
+)
+
+// CELPolicyWebhook validates requests with the CEL expressions of a Policy.
+// It is safe for concurrent use.
+type CELPolicyWebhook struct {
+	policy *Policy
+}
+
+func (s *CELPolicyWebhook) TimeoutSeconds() int32                        { return s.policy.TimeoutSeconds }
+func (s *CELPolicyWebhook) SideEffects() *admissionregv1.SideEffectClass { return &sideEffects }
+func (s *CELPolicyWebhook) MatchPolicy() *admissionregv1.MatchPolicyType { return s.policy.MatchPolicy }
+func (s *CELPolicyWebhook) NamespaceSelector() *metav1.LabelSelector {
+	return s.policy.NamespaceSelector
+}
+func (s *CELPolicyWebhook) ObjectSelector() *metav1.LabelSelector { return s.policy.ObjectSelector }
+func (s *CELPolicyWebhook) Rules() []admissionregv1.RuleWithOperations {
+	return s.policy.Rules
+}
+
+func (s *CELPolicyWebhook) FailurePolicy() admissionregv1.FailurePolicyType {
+	return *s.policy.FailurePolicy
+}
+
+func (s *CELPolicyWebhook) Name() string {
+	return s.policy.Name
+}
+
+// GetURI - where am I?
+func (s *CELPolicyWebhook) GetURI() string {
+	return "/" + s.policy.Name
+}
+
+// Validate - Make sure we're working with a well-formed Admission Request object
+func (s *CELPolicyWebhook) Validate(req admissionctl.Request) bool {
+	return req.UserInfo.Username != ""
+}
+
+// activation returns the variables validations of request are evaluated with
+func activation(request admissionctl.Request) (map[string]interface{}, error) {
+	vars := map[string]interface{}{}
+	raw, err := json.Marshal(request.AdmissionRequest)
+	if err != nil {
+		return nil, err
+	}
+	var req interface{}
+	if err := json.Unmarshal(raw, &req); err != nil {
+		return nil, err
+	}
+	vars["request"] = req
+	for name, obj := range map[string]runtime.RawExtension{
+		"object":    request.Object,
+		"oldObject": request.OldObject,
+	} {
+		var value interface{}
+		if len(obj.Raw) != 0 {
+			if err := json.Unmarshal(obj.Raw, &value); err != nil {
+				return nil, fmt.Errorf("couldn't parse %s: %s", name, err.Error())
+			}
+		}
+		vars[name] = value
+	}
+
+	groups := request.UserInfo.Groups
+	if groups == nil {
+		groups = []string{}
+	}
+	extra := map[string][]string{}
+	for key, values := range request.UserInfo.Extra {
+		extra[key] = values
+	}
+	vars["userInfo"] = map[string]interface{}{
+		"username": request.UserInfo.Username,
+		"uid":      request.UserInfo.UID,
+		"groups":   groups,
+		"extra":    extra,
+	}
+	return vars, nil
+}
+
+// Is the request authorized?
+func (s *CELPolicyWebhook) authorized(request admissionctl.Request) admissionctl.Response {
+	var ret admissionctl.Response
+	vars, err := activation(request)
+	if err != nil {
+		ret = admissionctl.Errored(http.StatusBadRequest, err)
+		ret.UID = request.AdmissionRequest.UID
+		return ret
+	}
+	// The API server gives up on the hook after its timeout anyway
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(s.policy.TimeoutSeconds)*time.Second)
+	defer cancel()
+	for _, v := range s.policy.Validations {
+		out, _, err := v.program.ContextEval(ctx, vars)
+		if err != nil {
+			log.Error(err, "Couldn't evaluate validation", "webhookName", s.policy.Name, "rule", v.Rule)
+			ret = audit.WithRule(admissionctl.Errored(http.StatusInternalServerError, fmt.Errorf("couldn't evaluate %s: %s", v.Rule, err.Error())), v.Rule)
+			ret.UID = request.AdmissionRequest.UID
+			return ret
+		}
+		if allowed, ok := out.Value().(bool); !ok || !allowed {
+			ret = audit.WithRule(admissionctl.Denied(v.Message), v.Rule)
+			ret.UID = request.AdmissionRequest.UID
+			return ret
+		}
+	}
+	ret = audit.WithRule(admissionctl.Allowed("All validations passed"), "validations")
+	ret.UID = request.AdmissionRequest.UID
+	return ret
+}
+
+// HandleRequest Decide if the incoming request is allowed
+func (s *CELPolicyWebhook) HandleRequest(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	request, version, err := utils.ParseHTTPRequest(r)
+	if err != nil {
+		log.Error(err, "Error parsing HTTP Request Body", "webhookName", s.policy.Name)
+		metrics.RecordDecodeFailure(s.policy.Name)
+		responsehelper.SendResponse(w, admissionctl.Errored(http.StatusBadRequest, err), version)
+		return
+	}
+	// Is this a valid request?
+	if !s.Validate(request) {
+		resp := admissionctl.Errored(http.StatusBadRequest, fmt.Errorf("Could not parse request"))
+		resp.UID = request.AdmissionRequest.UID
+		metrics.RecordResponse(s.policy.Name, request, resp, start)
+		audit.Record(s.policy.Name, request, resp)
+		responsehelper.SendResponse(w, resp, version)
+		return
+	}
+	// should the request be authorized?
+	resp, warnings := enforcement.Apply(s.policy.Name, s.authorized(request))
+	metrics.RecordResponse(s.policy.Name, request, resp, start)
+	audit.Record(s.policy.Name, request, resp)
+	responsehelper.SendResponse(w, resp, version, warnings...)
+}
+
+// NewWebhook creates a new webhook serving policy, which must come from Parse
+// or Load.
+func NewWebhook(policy *Policy) *CELPolicyWebhook {
+	return &CELPolicyWebhook{
+		policy: policy,
+	}
+}
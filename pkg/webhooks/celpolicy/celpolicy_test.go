@@ -0,0 +1,152 @@
+package celpolicy
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/lisa/k8s-webhook-framework/pkg/testutils"
+
+	"k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+const fixtures = "../../../fixtures/cel-policies"
+
+func TestFixtures(t *testing.T) {
+	policies, err := Load(fixtures + "/policies.yaml")
+	if err != nil {
+		t.Fatalf("Couldn't load policies: %s", err.Error())
+	}
+	if len(policies) != 1 {
+		t.Fatalf("Expected 1 policy, got %d", len(policies))
+	}
+	testutils.RunFixtures(t, NewWebhook(policies[0]), fixtures)
+}
+
+// policy returns a policy for ConfigMaps with a validation of expression
+func policy(expression string) string {
+	return `
+policies:
+- name: test-policy
+  rules:
+  - operations: ["*"]
+    apiGroups: [""]
+    apiVersions: ["*"]
+    resources: ["configmaps"]
+  validations:
+  - rule: test-rule
+    expression: '` + expression + `'
+`
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      string
+		expectedErr string
+	}{
+		{
+			name:   "valid",
+			config: policy(`userInfo.username == "kube:admin"`),
+		},
+		{
+			name:        "syntax error",
+			config:      policy(`userInfo.username ==`),
+			expectedErr: "test-rule",
+		},
+		{
+			name:        "not a bool",
+			config:      policy(`userInfo.username`),
+			expectedErr: "must evaluate to a bool",
+		},
+		{
+			name:        "undeclared variable",
+			config:      policy(`user.name == "kube:admin"`),
+			expectedErr: "undeclared reference",
+		},
+		{
+			name:        "unknown field",
+			config:      strings.Replace(policy(`true`), "validations:", "validation:", 1),
+			expectedErr: "unknown field",
+		},
+		{
+			name:        "invalid name",
+			config:      strings.Replace(policy(`true`), "test-policy", "Test/Policy", 1),
+			expectedErr: "policy name",
+		},
+		{
+			name:        "duplicate name",
+			config:      policy(`true`) + strings.Replace(policy(`true`), "policies:\n", "", 1),
+			expectedErr: "duplicate policy",
+		},
+		{
+			name:        "no validations",
+			config:      "policies:\n- name: test-policy\n  rules:\n  - operations: [\"*\"]\n",
+			expectedErr: "no validations",
+		},
+		{
+			name:        "no rules",
+			config:      "policies:\n- name: test-policy\n  validations:\n  - expression: 'true'\n",
+			expectedErr: "no rules",
+		},
+	}
+	for _, test := range tests {
+		policies, err := Parse([]byte(test.config))
+		if test.expectedErr == "" {
+			if err != nil {
+				t.Fatalf("%s: unexpected error: %s", test.name, err.Error())
+			}
+			if *policies[0].FailurePolicy != "Fail" || *policies[0].MatchPolicy != "Exact" || policies[0].TimeoutSeconds != defaultTimeoutSeconds {
+				t.Fatalf("%s: expected defaults to be filled in, got %+v", test.name, policies[0])
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), test.expectedErr) {
+			t.Fatalf("%s: expected an error containing %q, got %v", test.name, test.expectedErr, err)
+		}
+	}
+}
+
+func TestEvaluation(t *testing.T) {
+	gvk := metav1.GroupVersionKind{Group: "", Version: "v1", Kind: "ConfigMap"}
+	gvr := metav1.GroupVersionResource{Group: "", Version: "v1", Resource: "configmaps"}
+	obj := runtime.RawExtension{Raw: []byte(`{"metadata": {"name": "settings"}}`)}
+
+	tests := []struct {
+		expression   string
+		groups       []string
+		expectedCode int32
+	}{
+		{expression: `object.metadata.name == "settings"`, expectedCode: http.StatusOK},
+		{expression: `object.metadata.name != "settings"`, expectedCode: http.StatusForbidden},
+		{expression: `"dedicated-admins" in userInfo.groups`, groups: []string{"dedicated-admins"}, expectedCode: http.StatusOK},
+		// Users without groups still have the field
+		{expression: `size(userInfo.groups) == 0 && size(userInfo.extra) == 0`, expectedCode: http.StatusOK},
+		{expression: `oldObject == null && request.operation == "CREATE"`, expectedCode: http.StatusOK},
+		// Missing fields are errors
+		{expression: `object.metadata.labels.team == "sre"`, expectedCode: http.StatusInternalServerError},
+	}
+	for _, test := range tests {
+		policies, err := Parse([]byte(policy(test.expression)))
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", test.expression, err.Error())
+		}
+		hook := NewWebhook(policies[0])
+		httprequest, err := testutils.CreateHTTPRequest(hook.GetURI(), "abcd-123", gvk, gvr, v1beta1.Create, "test-user", test.groups, obj)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		response, err := testutils.SendHTTPRequest(httprequest, hook)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if response.UID == "" {
+			t.Fatalf("No tracking UID associated with the response.")
+		}
+		if response.Result == nil || response.Result.Code != test.expectedCode {
+			t.Fatalf("%s: expected a %d response, got %+v", test.expression, test.expectedCode, response.Result)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package celpolicy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+
+	"github.com/ghodss/yaml"
+	"github.com/google/cel-go/cel"
+	admissionregv1 "k8s.io/api/admissionregistration/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// defaultTimeoutSeconds is the TimeoutSeconds of policies which don't set
+	// one, the same as the built-in hooks'
+	defaultTimeoutSeconds int32 = 2
+	// interruptCheckFrequency is how many comprehension iterations an
+	// expression may run between checks of whether it is out of time
+	interruptCheckFrequency uint = 100
+)
+
+// policyNameRe matches names which make valid URIs and webhook names
+var policyNameRe = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
+// Config is a file of CEL policies, each served as its own webhook.
+type Config struct {
+	Policies []*Policy `json:"policies"`
+}
+
+// Policy is a webhook whose decisions are made by CEL expressions rather
+// than Go code. It is served at /<name>.
+type Policy struct {
+	// Name is the name of the webhook, which must not clash with another
+	Name string `json:"name"`
+	// Rules are the requests the API server sends to the webhook
+	Rules []admissionregv1.RuleWithOperations `json:"rules"`
+	// MatchPolicy defaults to Exact, which is filled in when the policy is
+	// loaded so that its registration states it, rather than leaving the API
+	// server to default it to Equivalent
+	MatchPolicy *admissionregv1.MatchPolicyType `json:"matchPolicy,omitempty"`
+	// FailurePolicy defaults to Fail, as policies typically restrict access
+	FailurePolicy *admissionregv1.FailurePolicyType `json:"failurePolicy,omitempty"`
+	// TimeoutSeconds defaults to 2
+	TimeoutSeconds int32 `json:"timeoutSeconds,omitempty"`
+	// NamespaceSelector and ObjectSelector narrow the requests sent to the
+	// webhook, see webhooks.Webhook
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	ObjectSelector    *metav1.LabelSelector `json:"objectSelector,omitempty"`
+	// Validations must all hold for a request to be allowed. The first which
+	// doesn't denies it.
+	Validations []*Validation `json:"validations"`
+}
+
+// Validation is a CEL expression which must evaluate to true for a request
+// to be allowed. It may refer to:
+//
+//	request    the AdmissionRequest, as in its JSON form
+//	object     the object being admitted, or null
+//	oldObject  the object being replaced, or null
+//	userInfo   the requesting user's username, uid, groups and extra, which
+//	           are always set
+//
+// Fields which may be missing, such as object.metadata.labels, should be
+// tested for with has() first: evaluating a missing one is an error, which
+// rejects the request.
+type Validation struct {
+	// Rule names the validation in the audit log
+	Rule string `json:"rule"`
+	// Expression is the CEL expression to evaluate
+	Expression string `json:"expression"`
+	// Message is the reason given to users whose requests are denied
+	Message string `json:"message"`
+
+	program cel.Program
+}
+
+// Parse reads CEL policies from YAML or JSON and compiles them. Unknown
+// fields, invalid or duplicate names, policies without rules or validations
+// and expressions which don't compile to a bool are all errors.
+func Parse(data []byte) ([]*Policy, error) {
+	raw, err := yaml.YAMLToJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	config := &Config{}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(config); err != nil {
+		return nil, err
+	}
+	env, err := newEnv()
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	for i, p := range config.Policies {
+		if p == nil {
+			return nil, fmt.Errorf("policy %d is empty", i)
+		}
+		if seen[p.Name] {
+			return nil, fmt.Errorf("duplicate policy %s", p.Name)
+		}
+		seen[p.Name] = true
+		if err := p.compile(env); err != nil {
+			return nil, err
+		}
+	}
+	return config.Policies, nil
+}
+
+// Load reads CEL policies from the file at path. See Parse.
+func Load(path string) ([]*Policy, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	policies, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CEL policies in %s: %s", path, err.Error())
+	}
+	return policies, nil
+}
+
+// newEnv declares the variables validations may refer to
+func newEnv() (*cel.Env, error) {
+	return cel.NewEnv(
+		cel.Variable("request", cel.DynType),
+		cel.Variable("object", cel.DynType),
+		cel.Variable("oldObject", cel.DynType),
+		cel.Variable("userInfo", cel.MapType(cel.StringType, cel.DynType)),
+	)
+}
+
+// compile validates p, fills in its defaults and compiles its validations
+func (p *Policy) compile(env *cel.Env) error {
+	if !policyNameRe.MatchString(p.Name) {
+		return fmt.Errorf("policy name %q must consist of lower case alphanumeric characters or '-'", p.Name)
+	}
+	if len(p.Rules) == 0 {
+		return fmt.Errorf("policy %s has no rules", p.Name)
+	}
+	if len(p.Validations) == 0 {
+		return fmt.Errorf("policy %s has no validations", p.Name)
+	}
+	if p.MatchPolicy == nil {
+		exact := admissionregv1.Exact
+		p.MatchPolicy = &exact
+	}
+	if p.FailurePolicy == nil {
+		fail := admissionregv1.Fail
+		p.FailurePolicy = &fail
+	}
+	if p.TimeoutSeconds == 0 {
+		p.TimeoutSeconds = defaultTimeoutSeconds
+	}
+	for _, selector := range []*metav1.LabelSelector{p.NamespaceSelector, p.ObjectSelector} {
+		if _, err := metav1.LabelSelectorAsSelector(selector); err != nil {
+			return fmt.Errorf("policy %s: %s", p.Name, err.Error())
+		}
+	}
+
+	for i, v := range p.Validations {
+		if v == nil {
+			return fmt.Errorf("policy %s: validation %d is empty", p.Name, i)
+		}
+		if v.Rule == "" {
+			v.Rule = fmt.Sprintf("validation-%d", i)
+		}
+		if v.Message == "" {
+			v.Message = fmt.Sprintf("Denied by %s", v.Rule)
+		}
+		ast, issues := env.Compile(v.Expression)
+		if issues != nil && issues.Err() != nil {
+			return fmt.Errorf("policy %s, %s: %s", p.Name, v.Rule, issues.Err().Error())
+		}
+		if !cel.BoolType.IsAssignableType(ast.OutputType()) {
+			return fmt.Errorf("policy %s, %s: expression must evaluate to a bool, not %s", p.Name, v.Rule, ast.OutputType())
+		}
+		program, err := env.Program(ast, cel.InterruptCheckFrequency(interruptCheckFrequency))
+		if err != nil {
+			return fmt.Errorf("policy %s, %s: %s", p.Name, v.Rule, err.Error())
+		}
+		v.program = program
+	}
+	return nil
+}
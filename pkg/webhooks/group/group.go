@@ -2,24 +2,28 @@ package group
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
-	"regexp"
-	"sync"
 	"time"
 
+	"github.com/lisa/k8s-webhook-framework/pkg/audit"
+	"github.com/lisa/k8s-webhook-framework/pkg/authz"
+	"github.com/lisa/k8s-webhook-framework/pkg/enforcement"
 	responsehelper "github.com/lisa/k8s-webhook-framework/pkg/helpers"
+	"github.com/lisa/k8s-webhook-framework/pkg/metrics"
+	"github.com/lisa/k8s-webhook-framework/pkg/policy"
 	"github.com/lisa/k8s-webhook-framework/pkg/webhooks/utils"
 	"k8s.io/api/admission/v1beta1"
 	admissionregv1 "k8s.io/api/admissionregistration/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
 	admissionctl "sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 )
 
-// GroupWebhook validates a Namespace change
+// GroupWebhook validates a Group change. It is safe for concurrent use.
 type GroupWebhook struct {
-	mu sync.Mutex
-	s  runtime.Scheme
+	s *runtime.Scheme
 }
 
 // GroupRequest represents a fragment of the data sent as part as part of
@@ -35,14 +39,11 @@ type groupRequest struct {
 }
 
 const (
-	WebhookName     string = "group-validation"
-	protectedGroups string = `(^osd-sre.*|^dedicated-admins$|^cluster-admins$|^layered-cs-sre-admins$)`
+	WebhookName string = "group-validation"
 )
 
 var (
-	protectedGroupsRe = regexp.MustCompile(protectedGroups)
-	clusterAdminUsers = []string{"kube:admin", "system:admin"}
-	adminGroups       = []string{"osd-sre-admins,osd-sre-cluster-admins"}
+	log = logf.Log.WithName(WebhookName)
 
 	sideEffects = admissionregv1.SideEffectClassNone
 	matchPolicy = admissionregv1.Exact
@@ -63,6 +64,15 @@ var (
 func (s *GroupWebhook) TimeoutSeconds() int32                        { return 2 }
 func (s *GroupWebhook) SideEffects() *admissionregv1.SideEffectClass { return &sideEffects }
 func (s *GroupWebhook) MatchPolicy() *admissionregv1.MatchPolicyType { return &matchPolicy }
+
+// NamespaceSelector is nil: Groups are cluster scoped, so the API server would
+// never apply it.
+func (s *GroupWebhook) NamespaceSelector() *metav1.LabelSelector { return nil }
+
+// ObjectSelector is nil: whoever changes a Group also sets its labels, so any
+// selector would let them exempt the Group from this hook.
+func (s *GroupWebhook) ObjectSelector() *metav1.LabelSelector { return nil }
+
 func (s *GroupWebhook) Rules() []admissionregv1.RuleWithOperations {
 	return rules
 }
@@ -83,37 +93,25 @@ func (s *GroupWebhook) GetURI() string {
 // Is the request authorized?
 func (s *GroupWebhook) authorized(request admissionctl.Request) admissionctl.Response {
 	var ret admissionctl.Response
-	// Cluster admins can do anything
-	if utils.SliceContains(request.AdmissionRequest.UserInfo.Username, clusterAdminUsers) {
-		ret = admissionctl.Allowed("Cluster admins may access")
-		ret.UID = request.AdmissionRequest.UID
-		return ret
-	}
+	p := policy.Current()
 	group := &groupRequest{}
 	err := json.Unmarshal(request.Object.Raw, group)
-	if err != nil {
+	// Cluster admins can do anything, even to Groups which don't parse
+	if err != nil && !p.ClusterAdmin()(request.AdmissionRequest.UserInfo) {
 		ret = admissionctl.Errored(http.StatusBadRequest, err)
 		ret.UID = request.AdmissionRequest.UID
 		return ret
 	}
-	if protectedGroupsRe.Match([]byte(group.Metadata.Name)) {
-		// protected group trying to be accessed, so let's check
-		for _, usersgroup := range request.AdmissionRequest.UserInfo.Groups {
-			// are they an admin?
-			if utils.SliceContains(usersgroup, adminGroups) {
-				ret = admissionctl.Allowed("Admin may access protected group")
-				ret.UID = request.AdmissionRequest.UID
-				return ret
-			}
-		}
-		ret = admissionctl.Denied("May not access protected group")
-		ret.UID = request.AdmissionRequest.UID
-		return ret
-	}
-	// it isn't protected, so let's not be bothered
-	ret = admissionctl.Allowed("RBAC allowed")
-	ret.UID = request.AdmissionRequest.UID
-	return ret
+	// protected group trying to be accessed, so let's check are they an admin?
+	protected := authz.If(err == nil && p.IsProtectedGroup(group.Metadata.Name))
+	decision := authz.Chain{
+		authz.Allow("cluster-admin", p.ClusterAdmin(), "Cluster admins may access"),
+		authz.Allow("protected-group-admin", authz.All(protected, p.GroupAdmin()), "Admin may access protected group"),
+		authz.Deny("protected-group", protected, "May not access protected group"),
+		// it isn't protected, so let's not be bothered
+		authz.Allow("rbac", authz.Always(), "RBAC allowed"),
+	}.Evaluate(request.AdmissionRequest.UserInfo)
+	return audit.WithRule(decision.Response(request), decision.Rule)
 }
 
 // Validate - Make sure we're working with a well-formed Admission Request object
@@ -128,22 +126,28 @@ func (s *GroupWebhook) Validate(req admissionctl.Request) bool {
 // HandleRequest Decide if the incoming request is allowed
 // Based on https://github.com/openshift/managed-cluster-validating-webhooks/blob/33aae59f588643fb8d1fe19cea9572c759586dd6/src/webhook/group_validation.py
 func (s *GroupWebhook) HandleRequest(w http.ResponseWriter, r *http.Request) {
-	var log = logf.Log.WithName(WebhookName)
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	request, _, err := utils.ParseHTTPRequest(r)
+	start := time.Now()
+	request, version, err := utils.ParseHTTPRequest(r)
 	if err != nil {
 		log.Error(err, "Error parsing HTTP Request Body")
-		responsehelper.SendResponse(w, admissionctl.Errored(http.StatusBadRequest, err))
+		metrics.RecordDecodeFailure(WebhookName)
+		responsehelper.SendResponse(w, admissionctl.Errored(http.StatusBadRequest, err), version)
 		return
 	}
 	// Is this a valid request?
 	if !s.Validate(request) {
-		responsehelper.SendResponse(w, admissionctl.Errored(http.StatusBadRequest, err))
+		resp := admissionctl.Errored(http.StatusBadRequest, fmt.Errorf("Could not parse Group from request"))
+		resp.UID = request.AdmissionRequest.UID
+		metrics.RecordResponse(WebhookName, request, resp, start)
+		audit.Record(WebhookName, request, resp)
+		responsehelper.SendResponse(w, resp, version)
 		return
 	}
 	// should the request be authorized?
-	responsehelper.SendResponse(w, s.authorized(request))
+	resp, warnings := enforcement.Apply(WebhookName, s.authorized(request))
+	metrics.RecordResponse(WebhookName, request, resp, start)
+	audit.Record(WebhookName, request, resp)
+	responsehelper.SendResponse(w, resp, version, warnings...)
 }
 
 // NewWebhook creates a new webhook
@@ -152,6 +156,6 @@ func NewWebhook() *GroupWebhook {
 	v1beta1.AddToScheme(scheme)
 
 	return &GroupWebhook{
-		s: *scheme,
+		s: scheme,
 	}
 }
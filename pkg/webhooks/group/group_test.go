@@ -128,6 +128,12 @@ func TestSREAdminUsers(t *testing.T) {
 	}
 	runGroupTests(t, tests)
 }
+
+// TestFixtures replays the captured AdmissionReviews in fixtures/groups
+func TestFixtures(t *testing.T) {
+	testutils.RunFixtures(t, NewWebhook(), "../../../fixtures/groups")
+}
+
 func TestMatchPollicy(t *testing.T) {
 	if NewWebhook().MatchPolicy() == nil {
 		t.Fatalf("nil Match Policy")
@@ -156,3 +162,25 @@ func TestSideEffects(t *testing.T) {
 		t.Fatalf("nil side effects")
 	}
 }
+
+func BenchmarkGroupWebhook(b *testing.B) {
+	gvk := metav1.GroupVersionKind{
+		Group:   "",
+		Version: "v1beta1",
+		Kind:    "Group",
+	}
+	gvr := metav1.GroupVersionResource{
+		Group:    "",
+		Version:  "v1beta1",
+		Resource: "groups",
+	}
+	obj := runtime.RawExtension{
+		Raw: []byte(fmt.Sprintf(testGroupRaw, "dedicated-admins", "bench-update-protected-group")),
+	}
+	body, err := testutils.CreateFakeRequestJSON("bench-update-protected-group", gvk, gvr, v1beta1.Update,
+		"test-user", []string{"dedicated-admins", "system:authenticated", "system:authenticated:oauth"}, obj)
+	if err != nil {
+		b.Fatalf("Expected no error, got %s", err.Error())
+	}
+	testutils.BenchmarkWebhook(b, NewWebhook(), body)
+}
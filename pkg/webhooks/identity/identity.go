@@ -4,12 +4,18 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"sync"
+	"time"
 
+	"github.com/lisa/k8s-webhook-framework/pkg/audit"
+	"github.com/lisa/k8s-webhook-framework/pkg/authz"
+	"github.com/lisa/k8s-webhook-framework/pkg/enforcement"
 	responsehelper "github.com/lisa/k8s-webhook-framework/pkg/helpers"
+	"github.com/lisa/k8s-webhook-framework/pkg/metrics"
+	"github.com/lisa/k8s-webhook-framework/pkg/policy"
 	"github.com/lisa/k8s-webhook-framework/pkg/webhooks/utils"
 	"k8s.io/api/admission/v1beta1"
 	admissionregv1 "k8s.io/api/admissionregistration/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	admissionctl "sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 
@@ -22,9 +28,6 @@ const (
 )
 
 var (
-	privilegedUsers = []string{"kube:admin", "system:admin", "system:serviceaccount:openshift-authentication:oauth-openshift"}
-	adminGroups     = []string{"osd-sre-admins", "osd-sre-cluster-admins"}
-
 	log = logf.Log.WithName(WebhookName)
 
 	sideEffects = admissionregv1.SideEffectClassNone
@@ -50,15 +53,24 @@ type identityRequest struct {
 	ProviderName string `json:"providerName"`
 }
 
-// IdentityWebhook validates a Namespace change
+// IdentityWebhook validates an Identity change. It is safe for concurrent
+// use.
 type IdentityWebhook struct {
-	mu sync.Mutex
-	s  runtime.Scheme
+	s *runtime.Scheme
 }
 
 func (s *IdentityWebhook) TimeoutSeconds() int32                        { return 2 }
 func (s *IdentityWebhook) SideEffects() *admissionregv1.SideEffectClass { return &sideEffects }
 func (s *IdentityWebhook) MatchPolicy() *admissionregv1.MatchPolicyType { return &matchPolicy }
+
+// NamespaceSelector is nil: Identities are cluster scoped, so the API server
+// would never apply it.
+func (s *IdentityWebhook) NamespaceSelector() *metav1.LabelSelector { return nil }
+
+// ObjectSelector is nil: whoever changes an Identity also sets its labels, so
+// any selector would let them exempt the Identity from this hook.
+func (s *IdentityWebhook) ObjectSelector() *metav1.LabelSelector { return nil }
+
 func (s *IdentityWebhook) Rules() []admissionregv1.RuleWithOperations {
 	return rules
 }
@@ -99,53 +111,46 @@ func (s *IdentityWebhook) authorized(request admissionctl.Request) admissionctl.
 	}
 	if err != nil {
 		ret = admissionctl.Errored(http.StatusBadRequest, err)
-		return ret
-	}
-	// Admin user
-	if utils.SliceContains(request.AdmissionRequest.UserInfo.Username, privilegedUsers) {
-		ret = admissionctl.Allowed("Allowed")
 		ret.UID = request.AdmissionRequest.UID
 		return ret
 	}
-	if idReq.ProviderName == defaultIdentityProvider {
-		for _, group := range request.AdmissionRequest.UserInfo.Groups {
-			if utils.SliceContains(group, adminGroups) {
-				ret = admissionctl.Allowed("")
-				ret.UID = request.AdmissionRequest.UID
-				return ret
-			}
-		}
-		ret = admissionctl.Denied("Permission denied")
-		ret.UID = request.AdmissionRequest.UID
-		return ret
-	}
-
-	ret = admissionctl.Allowed("Allowed by RBAC")
-	ret.UID = request.AdmissionRequest.UID
-	return ret
-
+	p := policy.Current()
+	sreIdentity := authz.If(idReq.ProviderName == defaultIdentityProvider)
+	decision := authz.Chain{
+		// Admin user
+		authz.Allow("privileged-user", p.PrivilegedUser(), "Allowed"),
+		authz.Allow("sre-identity-admin", authz.All(sreIdentity, p.SREAdmin()), ""),
+		authz.Deny("sre-identity", sreIdentity, "Permission denied"),
+		authz.Allow("rbac", authz.Always(), "Allowed by RBAC"),
+	}.Evaluate(request.AdmissionRequest.UserInfo)
+	return audit.WithRule(decision.Response(request), decision.Rule)
 }
 
 // HandleRequest Decide if the incoming request is allowed
 func (s *IdentityWebhook) HandleRequest(w http.ResponseWriter, r *http.Request) {
-
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	request, _, err := utils.ParseHTTPRequest(r)
+	start := time.Now()
+	request, version, err := utils.ParseHTTPRequest(r)
 	if err != nil {
 		log.Error(err, "Error parsing HTTP Request Body")
-		responsehelper.SendResponse(w, admissionctl.Errored(http.StatusBadRequest, err))
+		metrics.RecordDecodeFailure(WebhookName)
+		responsehelper.SendResponse(w, admissionctl.Errored(http.StatusBadRequest, err), version)
 		return
 	}
 	// Is this a valid request?
 	if !s.Validate(request) {
-		responsehelper.SendResponse(w,
-			admissionctl.Errored(http.StatusBadRequest,
-				fmt.Errorf("Could not parse Namespace from request")))
+		resp := admissionctl.Errored(http.StatusBadRequest,
+			fmt.Errorf("Could not parse Namespace from request"))
+		resp.UID = request.AdmissionRequest.UID
+		metrics.RecordResponse(WebhookName, request, resp, start)
+		audit.Record(WebhookName, request, resp)
+		responsehelper.SendResponse(w, resp, version)
 		return
 	}
 	// should the request be authorized?
-	responsehelper.SendResponse(w, s.authorized(request))
+	resp, warnings := enforcement.Apply(WebhookName, s.authorized(request))
+	metrics.RecordResponse(WebhookName, request, resp, start)
+	audit.Record(WebhookName, request, resp)
+	responsehelper.SendResponse(w, resp, version, warnings...)
 }
 
 // NewWebhook creates a new webhook
@@ -154,6 +159,6 @@ func NewWebhook() *IdentityWebhook {
 	v1beta1.AddToScheme(scheme)
 
 	return &IdentityWebhook{
-		s: *scheme,
+		s: scheme,
 	}
 }
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"testing"
 
+	"github.com/lisa/k8s-webhook-framework/pkg/policy"
 	"github.com/lisa/k8s-webhook-framework/pkg/testutils"
 
 	"k8s.io/api/admission/v1beta1"
@@ -89,7 +90,7 @@ func TestThing(t *testing.T) {
 			identityName:    fmt.Sprintf("%s:test", defaultIdentityProvider),
 			providerName:    defaultIdentityProvider,
 			username:        "ded-admin",
-			userGroups:      []string{adminGroups[0], "system:authenticated", "system:authenticated:oauth"},
+			userGroups:      []string{policy.Default().SREAdminGroups[0], "system:authenticated", "system:authenticated:oauth"},
 			operation:       v1beta1.Update,
 			shouldBeAllowed: true,
 		},
@@ -150,6 +151,12 @@ func TestThing(t *testing.T) {
 func TestBadRequests(t *testing.T) {
 	t.Skip()
 }
+
+// TestFixtures replays the captured AdmissionReviews in fixtures/identities
+func TestFixtures(t *testing.T) {
+	testutils.RunFixtures(t, NewWebhook(), "../../../fixtures/identities")
+}
+
 func TestMatchPollicy(t *testing.T) {
 	if NewWebhook().MatchPolicy() == nil {
 		t.Fatalf("nil Match Policy")
@@ -179,3 +186,25 @@ func TestSideEffects(t *testing.T) {
 		t.Fatalf("nil side effects")
 	}
 }
+
+func BenchmarkIdentityWebhook(b *testing.B) {
+	gvk := metav1.GroupVersionKind{
+		Group:   "user.openshift.io",
+		Version: "v1",
+		Kind:    "Identity",
+	}
+	gvr := metav1.GroupVersionResource{
+		Group:    "user.openshift.io",
+		Version:  "v1",
+		Resource: "identities",
+	}
+	obj := runtime.RawExtension{
+		Raw: []byte(fmt.Sprintf(testIdentityRaw, "OpenShift_SRE:test", "bench-create-sre-identity", "OpenShift_SRE")),
+	}
+	body, err := testutils.CreateFakeRequestJSON("bench-create-sre-identity", gvk, gvr, v1beta1.Create,
+		"test-user", []string{"dedicated-admins", "system:authenticated", "system:authenticated:oauth"}, obj)
+	if err != nil {
+		b.Fatalf("Expected no error, got %s", err.Error())
+	}
+	testutils.BenchmarkWebhook(b, NewWebhook(), body)
+}
@@ -0,0 +1,231 @@
+package webhooks
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	admissionregv1 "k8s.io/api/admissionregistration/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	admissionctl "sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// namespaceResource is the only cluster-scoped resource whose requests carry a
+// namespace: that of the Namespace itself
+var namespaceResource = metav1.GroupResource{Group: "", Resource: "namespaces"}
+
+// NamespaceLabels looks up the labels of a namespace, so that namespace
+// selectors can be evaluated.
+type NamespaceLabels func(name string) (map[string]string, error)
+
+// Matcher decides which admission requests the API server sends to a
+// webhook, following kube-apiserver's rules: a request must match one of the
+// Rules (operation, API group, version, resource and scope) as well as the
+// NamespaceSelector and ObjectSelector. Nil selectors match everything.
+type Matcher struct {
+	Rules             []admissionregv1.RuleWithOperations
+	MatchPolicy       *admissionregv1.MatchPolicyType
+	NamespaceSelector *metav1.LabelSelector
+	ObjectSelector    *metav1.LabelSelector
+}
+
+// NewMatcher returns the Matcher for hook's registration.
+func NewMatcher(hook Webhook) Matcher {
+	return Matcher{
+		Rules:             hook.Rules(),
+		MatchPolicy:       hook.MatchPolicy(),
+		NamespaceSelector: hook.NamespaceSelector(),
+		ObjectSelector:    hook.ObjectSelector(),
+	}
+}
+
+// Matches reports whether the API server would send req to the webhook.
+// namespaceLabels is needed for the NamespaceSelector of requests in a
+// namespace; if it is nil the NamespaceSelector is only applied to requests
+// for Namespaces themselves, whose labels are in the request.
+func (m Matcher) Matches(req admissionctl.Request, namespaceLabels NamespaceLabels) (bool, error) {
+	if !m.matchesRules(req) {
+		return false, nil
+	}
+	matched, err := m.matchesNamespaceSelector(req, namespaceLabels)
+	if err != nil || !matched {
+		return false, err
+	}
+	return m.matchesObjectSelector(req)
+}
+
+// matchesRules checks the request against the rules. With an Equivalent match
+// policy, the API server also sends the webhook requests for another version
+// of a resource it asked for, or for a resource in another group which is the
+// same underneath (eg, extensions and apps Deployments), converted to the
+// version the webhook asked for. The request then carries what the user
+// originally asked for in RequestResource, so that is checked as well. Like
+// admissionregistration/v1, an unset match policy is Equivalent.
+func (m Matcher) matchesRules(req admissionctl.Request) bool {
+	equivalent := m.MatchPolicy == nil || *m.MatchPolicy == admissionregv1.Equivalent
+	for _, rule := range m.Rules {
+		if !matchesOperation(rule.Operations, string(req.Operation)) || !matchesScope(rule.Rule, req) {
+			continue
+		}
+		if matchesResource(rule.Rule, req.Resource, req.SubResource, equivalent) {
+			return true
+		}
+		if equivalent && req.RequestResource != nil &&
+			matchesResource(rule.Rule, *req.RequestResource, req.RequestSubResource, equivalent) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesOperation(operations []admissionregv1.OperationType, operation string) bool {
+	for _, op := range operations {
+		if op == admissionregv1.OperationAll || string(op) == operation {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesScope checks the request against rule.Scope. Namespaces are cluster
+// scoped although their requests carry a namespace.
+func matchesScope(rule admissionregv1.Rule, req admissionctl.Request) bool {
+	if rule.Scope == nil || *rule.Scope == admissionregv1.AllScopes {
+		return true
+	}
+	isNamespace := isNamespaceResource(req.Resource)
+	switch *rule.Scope {
+	case admissionregv1.NamespacedScope:
+		return !isNamespace && req.Namespace != ""
+	case admissionregv1.ClusterScope:
+		return isNamespace || req.Namespace == ""
+	}
+	return false
+}
+
+// matchesResource checks gvr and subResource against the rule's groups,
+// versions and resources. In resources "*" is every resource but none of
+// their subresources, "*/*" everything, "nodes/*" nodes and all of their
+// subresources and "*/status" the status of every resource. Versions are not
+// checked when any version of the resource will do.
+func matchesResource(rule admissionregv1.Rule, gvr metav1.GroupVersionResource, subResource string, anyVersion bool) bool {
+	if !matchesString(rule.APIGroups, gvr.Group) {
+		return false
+	}
+	if !anyVersion && !matchesString(rule.APIVersions, gvr.Version) {
+		return false
+	}
+	for _, r := range rule.Resources {
+		res, sub := splitResource(r)
+		if (res == "*" || res == gvr.Resource) && (sub == "*" || sub == subResource) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitResource splits pods/status into pods and status
+func splitResource(resource string) (string, string) {
+	parts := strings.SplitN(resource, "/", 2)
+	if len(parts) == 2 {
+		return parts[0], parts[1]
+	}
+	return parts[0], ""
+}
+
+func matchesString(list []string, s string) bool {
+	for _, item := range list {
+		if item == "*" || item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func isNamespaceResource(gvr metav1.GroupVersionResource) bool {
+	return gvr.Group == namespaceResource.Group && gvr.Resource == namespaceResource.Resource
+}
+
+// matchesNamespaceSelector checks the labels of the request's namespace
+// against the NamespaceSelector. Requests for cluster-scoped resources other
+// than Namespaces always match, as do the creation and update of a Namespace
+// whose new labels match.
+func (m Matcher) matchesNamespaceSelector(req admissionctl.Request, namespaceLabels NamespaceLabels) (bool, error) {
+	if m.NamespaceSelector == nil {
+		return true, nil
+	}
+	isNamespace := isNamespaceResource(req.Resource)
+	if req.Namespace == "" && !isNamespace {
+		return true, nil
+	}
+	selector, err := metav1.LabelSelectorAsSelector(m.NamespaceSelector)
+	if err != nil {
+		return false, fmt.Errorf("invalid namespaceSelector: %s", err.Error())
+	}
+	if selector.Empty() {
+		return true, nil
+	}
+
+	var nsLabels map[string]string
+	if isNamespace && req.SubResource == "" && (req.Operation == "CREATE" || req.Operation == "UPDATE") {
+		if nsLabels, err = objectLabels(req.Object); err != nil {
+			return false, err
+		}
+	} else {
+		if namespaceLabels == nil {
+			// No way of knowing
+			return true, nil
+		}
+		name := req.Namespace
+		if name == "" {
+			name = req.Name
+		}
+		if nsLabels, err = namespaceLabels(name); err != nil {
+			return false, fmt.Errorf("couldn't get the labels of namespace %s: %s", name, err.Error())
+		}
+	}
+	return selector.Matches(labels.Set(nsLabels)), nil
+}
+
+// matchesObjectSelector checks the labels of the object and the old object
+// against the ObjectSelector; either matching is enough.
+func (m Matcher) matchesObjectSelector(req admissionctl.Request) (bool, error) {
+	if m.ObjectSelector == nil {
+		return true, nil
+	}
+	selector, err := metav1.LabelSelectorAsSelector(m.ObjectSelector)
+	if err != nil {
+		return false, fmt.Errorf("invalid objectSelector: %s", err.Error())
+	}
+	if selector.Empty() {
+		return true, nil
+	}
+	for _, raw := range []runtime.RawExtension{req.Object, req.OldObject} {
+		if len(raw.Raw) == 0 {
+			// Eg, the OldObject of a CREATE
+			continue
+		}
+		objLabels, err := objectLabels(raw)
+		if err != nil {
+			// The API server can't match an object without metadata either
+			continue
+		}
+		if selector.Matches(labels.Set(objLabels)) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func objectLabels(raw runtime.RawExtension) (map[string]string, error) {
+	if len(raw.Raw) == 0 {
+		return nil, nil
+	}
+	obj := metav1.PartialObjectMetadata{}
+	if err := json.Unmarshal(raw.Raw, &obj); err != nil {
+		return nil, fmt.Errorf("couldn't get the labels of the object: %s", err.Error())
+	}
+	return obj.Labels, nil
+}
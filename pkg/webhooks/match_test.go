@@ -0,0 +1,431 @@
+package webhooks
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/lisa/k8s-webhook-framework/pkg/testutils"
+	"github.com/lisa/k8s-webhook-framework/pkg/webhooks/namespace"
+	"github.com/lisa/k8s-webhook-framework/pkg/webhooks/subscription"
+	"github.com/lisa/k8s-webhook-framework/pkg/webhooks/utils"
+
+	"k8s.io/api/admission/v1beta1"
+	admissionregv1 "k8s.io/api/admissionregistration/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	admissionctl "sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+var (
+	namespacedScope = admissionregv1.NamespacedScope
+	clusterScope    = admissionregv1.ClusterScope
+	exact           = admissionregv1.Exact
+	equivalent      = admissionregv1.Equivalent
+)
+
+func createRequest(operation v1beta1.Operation, gvr metav1.GroupVersionResource, subResource, ns, obj string) admissionctl.Request {
+	return admissionctl.Request{
+		AdmissionRequest: v1beta1.AdmissionRequest{
+			UID:         "abcd-123",
+			Operation:   operation,
+			Resource:    gvr,
+			SubResource: subResource,
+			Namespace:   ns,
+			Object:      runtime.RawExtension{Raw: []byte(obj)},
+		},
+	}
+}
+
+func rule(operation admissionregv1.OperationType, groups, versions, resources []string, scope *admissionregv1.ScopeType) admissionregv1.RuleWithOperations {
+	return admissionregv1.RuleWithOperations{
+		Operations: []admissionregv1.OperationType{operation},
+		Rule: admissionregv1.Rule{
+			APIGroups:   groups,
+			APIVersions: versions,
+			Resources:   resources,
+			Scope:       scope,
+		},
+	}
+}
+
+func TestMatchesRules(t *testing.T) {
+	nodes := metav1.GroupVersionResource{Group: "", Version: "v1", Resource: "nodes"}
+	pods := metav1.GroupVersionResource{Group: "", Version: "v1", Resource: "pods"}
+	namespaces := metav1.GroupVersionResource{Group: "", Version: "v1", Resource: "namespaces"}
+	deployments := metav1.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+
+	tests := []struct {
+		name        string
+		rule        admissionregv1.RuleWithOperations
+		matchPolicy *admissionregv1.MatchPolicyType
+		req         admissionctl.Request
+		expected    bool
+	}{
+		{
+			name:     "exact resource",
+			rule:     rule("UPDATE", []string{""}, []string{"v1"}, []string{"nodes"}, nil),
+			req:      createRequest(v1beta1.Update, nodes, "", "", "{}"),
+			expected: true,
+		},
+		{
+			name:     "other operation",
+			rule:     rule("UPDATE", []string{""}, []string{"v1"}, []string{"nodes"}, nil),
+			req:      createRequest(v1beta1.Delete, nodes, "", "", "{}"),
+			expected: false,
+		},
+		{
+			name:     "any operation",
+			rule:     rule("*", []string{""}, []string{"v1"}, []string{"nodes"}, nil),
+			req:      createRequest(v1beta1.Connect, nodes, "", "", "{}"),
+			expected: true,
+		},
+		{
+			name:     "resource without its subresources",
+			rule:     rule("UPDATE", []string{""}, []string{"v1"}, []string{"nodes"}, nil),
+			req:      createRequest(v1beta1.Update, nodes, "status", "", "{}"),
+			expected: false,
+		},
+		{
+			name:     "resource and its subresources",
+			rule:     rule("UPDATE", []string{""}, []string{"v1"}, []string{"nodes/*"}, nil),
+			req:      createRequest(v1beta1.Update, nodes, "status", "", "{}"),
+			expected: true,
+		},
+		{
+			name:     "all resources without subresources",
+			rule:     rule("UPDATE", []string{"*"}, []string{"*"}, []string{"*"}, nil),
+			req:      createRequest(v1beta1.Update, nodes, "status", "", "{}"),
+			expected: false,
+		},
+		{
+			name:     "all resources and subresources",
+			rule:     rule("UPDATE", []string{"*"}, []string{"*"}, []string{"*/*"}, nil),
+			req:      createRequest(v1beta1.Update, nodes, "status", "", "{}"),
+			expected: true,
+		},
+		{
+			name:     "subresource of all resources",
+			rule:     rule("UPDATE", []string{"*"}, []string{"*"}, []string{"*/status"}, nil),
+			req:      createRequest(v1beta1.Update, pods, "status", "default", "{}"),
+			expected: true,
+		},
+		{
+			name:     "other group",
+			rule:     rule("CREATE", []string{"apps"}, []string{"*"}, []string{"pods"}, nil),
+			req:      createRequest(v1beta1.Create, pods, "", "default", "{}"),
+			expected: false,
+		},
+		{
+			name:     "namespaced scope",
+			rule:     rule("CREATE", []string{""}, []string{"v1"}, []string{"*"}, &namespacedScope),
+			req:      createRequest(v1beta1.Create, pods, "", "default", "{}"),
+			expected: true,
+		},
+		{
+			name:     "namespaced scope excludes cluster resources",
+			rule:     rule("CREATE", []string{""}, []string{"v1"}, []string{"*"}, &namespacedScope),
+			req:      createRequest(v1beta1.Create, nodes, "", "", "{}"),
+			expected: false,
+		},
+		{
+			name:     "namespaced scope excludes namespaces",
+			rule:     rule("CREATE", []string{""}, []string{"v1"}, []string{"*"}, &namespacedScope),
+			req:      createRequest(v1beta1.Create, namespaces, "", "my-namespace", "{}"),
+			expected: false,
+		},
+		{
+			name:     "cluster scope includes namespaces",
+			rule:     rule("CREATE", []string{""}, []string{"v1"}, []string{"*"}, &clusterScope),
+			req:      createRequest(v1beta1.Create, namespaces, "", "my-namespace", "{}"),
+			expected: true,
+		},
+		{
+			name:     "cluster scope excludes namespaced resources",
+			rule:     rule("CREATE", []string{""}, []string{"v1"}, []string{"*"}, &clusterScope),
+			req:      createRequest(v1beta1.Create, pods, "", "default", "{}"),
+			expected: false,
+		},
+		{
+			name:        "exact policy ignores other versions",
+			rule:        rule("CREATE", []string{"apps"}, []string{"v1beta1"}, []string{"deployments"}, nil),
+			matchPolicy: &exact,
+			req:         createRequest(v1beta1.Create, deployments, "", "default", "{}"),
+			expected:    false,
+		},
+		{
+			name:        "equivalent policy matches other versions",
+			rule:        rule("CREATE", []string{"apps"}, []string{"v1beta1"}, []string{"deployments"}, nil),
+			matchPolicy: &equivalent,
+			req:         createRequest(v1beta1.Create, deployments, "", "default", "{}"),
+			expected:    true,
+		},
+		{
+			name:     "unset policy is equivalent",
+			rule:     rule("CREATE", []string{"apps"}, []string{"v1beta1"}, []string{"deployments"}, nil),
+			req:      createRequest(v1beta1.Create, deployments, "", "default", "{}"),
+			expected: true,
+		},
+		{
+			name:        "equivalent policy matches the requested resource",
+			rule:        rule("CREATE", []string{"extensions"}, []string{"v1beta1"}, []string{"deployments"}, nil),
+			matchPolicy: &equivalent,
+			req: func() admissionctl.Request {
+				req := createRequest(v1beta1.Create, deployments, "", "default", "{}")
+				req.RequestResource = &metav1.GroupVersionResource{Group: "extensions", Version: "v1beta1", Resource: "deployments"}
+				return req
+			}(),
+			expected: true,
+		},
+	}
+	for _, test := range tests {
+		m := Matcher{
+			Rules:       []admissionregv1.RuleWithOperations{test.rule},
+			MatchPolicy: test.matchPolicy,
+		}
+		matched, err := m.Matches(test.req, nil)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", test.name, err.Error())
+		}
+		if matched != test.expected {
+			t.Fatalf("%s: expected match=%t, got %t", test.name, test.expected, matched)
+		}
+	}
+}
+
+func TestMatchesSelectors(t *testing.T) {
+	pods := metav1.GroupVersionResource{Group: "", Version: "v1", Resource: "pods"}
+	nodes := metav1.GroupVersionResource{Group: "", Version: "v1", Resource: "nodes"}
+	namespaces := metav1.GroupVersionResource{Group: "", Version: "v1", Resource: "namespaces"}
+	managed := &metav1.LabelSelector{MatchLabels: map[string]string{"managed": "true"}}
+	namespaceLabels := func(name string) (map[string]string, error) {
+		switch name {
+		case "managed-ns":
+			return map[string]string{"managed": "true"}, nil
+		case "customer-ns":
+			return map[string]string{}, nil
+		}
+		return nil, fmt.Errorf("namespace %s not found", name)
+	}
+
+	tests := []struct {
+		name              string
+		namespaceSelector *metav1.LabelSelector
+		objectSelector    *metav1.LabelSelector
+		req               admissionctl.Request
+		namespaceLabels   NamespaceLabels
+		expected          bool
+		expectedErr       bool
+	}{
+		{
+			name:              "namespace labels match",
+			namespaceSelector: managed,
+			req:               createRequest(v1beta1.Create, pods, "", "managed-ns", "{}"),
+			namespaceLabels:   namespaceLabels,
+			expected:          true,
+		},
+		{
+			name:              "namespace labels don't match",
+			namespaceSelector: managed,
+			req:               createRequest(v1beta1.Create, pods, "", "customer-ns", "{}"),
+			namespaceLabels:   namespaceLabels,
+			expected:          false,
+		},
+		{
+			name:              "unknown namespace labels",
+			namespaceSelector: managed,
+			req:               createRequest(v1beta1.Create, pods, "", "customer-ns", "{}"),
+			expected:          true,
+		},
+		{
+			name:              "namespace lookup fails",
+			namespaceSelector: managed,
+			req:               createRequest(v1beta1.Create, pods, "", "missing-ns", "{}"),
+			namespaceLabels:   namespaceLabels,
+			expectedErr:       true,
+		},
+		{
+			name:              "cluster-scoped resources ignore the namespace selector",
+			namespaceSelector: managed,
+			req:               createRequest(v1beta1.Create, nodes, "", "", "{}"),
+			namespaceLabels:   namespaceLabels,
+			expected:          true,
+		},
+		{
+			name:              "namespaces are matched by their own labels",
+			namespaceSelector: managed,
+			req:               createRequest(v1beta1.Create, namespaces, "", "new-ns", `{"metadata": {"name": "new-ns", "labels": {"managed": "true"}}}`),
+			expected:          true,
+		},
+		{
+			name:              "empty selector",
+			namespaceSelector: &metav1.LabelSelector{},
+			req:               createRequest(v1beta1.Create, pods, "", "missing-ns", "{}"),
+			namespaceLabels:   namespaceLabels,
+			expected:          true,
+		},
+		{
+			name:           "object labels match",
+			objectSelector: managed,
+			req:            createRequest(v1beta1.Create, pods, "", "default", `{"metadata": {"labels": {"managed": "true"}}}`),
+			expected:       true,
+		},
+		{
+			name:           "object labels don't match",
+			objectSelector: managed,
+			req:            createRequest(v1beta1.Create, pods, "", "default", `{"metadata": {"labels": {"managed": "false"}}}`),
+			expected:       false,
+		},
+		{
+			name:           "old object labels match",
+			objectSelector: managed,
+			req: func() admissionctl.Request {
+				req := createRequest(v1beta1.Update, pods, "", "default", `{"metadata": {}}`)
+				req.OldObject = runtime.RawExtension{Raw: []byte(`{"metadata": {"labels": {"managed": "true"}}}`)}
+				return req
+			}(),
+			expected: true,
+		},
+		{
+			name:           "invalid selector",
+			objectSelector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "managed", Operator: "Sometimes"}}},
+			req:            createRequest(v1beta1.Create, pods, "", "default", "{}"),
+			expectedErr:    true,
+		},
+	}
+	for _, test := range tests {
+		m := Matcher{
+			Rules:             []admissionregv1.RuleWithOperations{rule("*", []string{"*"}, []string{"*"}, []string{"*"}, nil)},
+			NamespaceSelector: test.namespaceSelector,
+			ObjectSelector:    test.objectSelector,
+		}
+		matched, err := m.Matches(test.req, test.namespaceLabels)
+		if (err != nil) != test.expectedErr {
+			t.Fatalf("%s: expected error=%t, got %v", test.name, test.expectedErr, err)
+		}
+		if matched != test.expected {
+			t.Fatalf("%s: expected match=%t, got %t", test.name, test.expected, matched)
+		}
+	}
+}
+
+func TestCheckRoute(t *testing.T) {
+	hook := namespace.NewWebhook()
+	gvk := metav1.GroupVersionKind{Group: "", Version: "v1", Kind: "Namespace"}
+	gvr := metav1.GroupVersionResource{Group: "", Version: "v1", Resource: "namespaces"}
+	obj := runtime.RawExtension{Raw: []byte(`{"metadata": {"name": "my-namespace"}}`)}
+
+	tests := []struct {
+		action       MisroutedAction
+		operation    v1beta1.Operation
+		expectedCode int32
+	}{
+		{action: MisroutedReject, operation: v1beta1.Update, expectedCode: http.StatusOK},
+		// The namespace webhook only handles updates
+		{action: MisroutedReject, operation: v1beta1.Create, expectedCode: http.StatusBadRequest},
+		{action: MisroutedLog, operation: v1beta1.Create, expectedCode: http.StatusOK},
+	}
+	for _, test := range tests {
+		httprequest, err := testutils.CreateHTTPRequest(hook.GetURI(), "abcd-123", gvk, gvr, test.operation, "test-user", []string{"system:authenticated"}, obj)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		recorder := httptest.NewRecorder()
+		CheckRoute(hook, test.action)(recorder, httprequest)
+		review := v1beta1.AdmissionReview{}
+		if err := json.Unmarshal(recorder.Body.Bytes(), &review); err != nil {
+			t.Fatalf("Couldn't parse response: %s", err.Error())
+		}
+		response := review.Response
+		if response == nil || response.UID != "abcd-123" || response.Result == nil || response.Result.Code != test.expectedCode {
+			t.Fatalf("Expected a %d response to a %s with action %s, got %+v", test.expectedCode, test.operation, test.action, response)
+		}
+	}
+}
+
+// selectorWebhook is a Webhook with the given selectors
+type selectorWebhook struct {
+	Webhook
+	namespaceSelector *metav1.LabelSelector
+	objectSelector    *metav1.LabelSelector
+}
+
+func (s *selectorWebhook) NamespaceSelector() *metav1.LabelSelector { return s.namespaceSelector }
+func (s *selectorWebhook) ObjectSelector() *metav1.LabelSelector    { return s.objectSelector }
+
+func TestNewMatcherSelectors(t *testing.T) {
+	namespaces := metav1.GroupVersionResource{Group: "", Version: "v1", Resource: "namespaces"}
+	hook := &selectorWebhook{
+		Webhook:           namespace.NewWebhook(),
+		namespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"managed": "true"}},
+		objectSelector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+			{Key: "exempt", Operator: metav1.LabelSelectorOpDoesNotExist},
+		}},
+	}
+	tests := []struct {
+		labels   string
+		expected bool
+	}{
+		{labels: `{"managed": "true"}`, expected: true},
+		{labels: `{"managed": "false"}`, expected: false},
+		{labels: `{"managed": "true", "exempt": "yes"}`, expected: false},
+	}
+	m := NewMatcher(hook)
+	for _, test := range tests {
+		req := createRequest(v1beta1.Update, namespaces, "", "my-namespace", fmt.Sprintf(`{"metadata": {"labels": %s}}`, test.labels))
+		matched, err := m.Matches(req, nil)
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err.Error())
+		}
+		if matched != test.expected {
+			t.Fatalf("Expected match=%t for a namespace labelled %s, got %t", test.expected, test.labels, matched)
+		}
+	}
+}
+
+func TestPrivilegedNamespaceSelector(t *testing.T) {
+	subscriptions := metav1.GroupVersionResource{Group: "operators.coreos.com", Version: "v1alpha1", Resource: "subscriptions"}
+	labels := map[string]map[string]string{
+		"customer":           {utils.CustomerNamespaceLabel: "true"},
+		"openshift-logging":  {},
+		"unlabelled-project": {},
+	}
+	namespaceLabels := func(name string) (map[string]string, error) {
+		return labels[name], nil
+	}
+	tests := map[string]bool{
+		"customer":           false,
+		"openshift-logging":  true,
+		"unlabelled-project": true,
+	}
+	m := NewMatcher(subscription.NewWebhook())
+	for ns, expected := range tests {
+		req := createRequest(v1beta1.Create, subscriptions, "", ns, "{}")
+		matched, err := m.Matches(req, namespaceLabels)
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err.Error())
+		}
+		if matched != expected {
+			t.Fatalf("Expected match=%t for a Subscription in %s, got %t", expected, ns, matched)
+		}
+	}
+}
+
+func TestRegisteredSelectors(t *testing.T) {
+	for name, factory := range Webhooks {
+		hook := factory()
+		for kind, selector := range map[string]*metav1.LabelSelector{
+			"namespaceSelector": hook.NamespaceSelector(),
+			"objectSelector":    hook.ObjectSelector(),
+		} {
+			if selector == nil {
+				continue
+			}
+			if _, err := metav1.LabelSelectorAsSelector(selector); err != nil {
+				t.Fatalf("Invalid %s for webhook %s: %s", kind, name, err.Error())
+			}
+		}
+	}
+}
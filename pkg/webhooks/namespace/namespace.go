@@ -3,14 +3,19 @@ package namespace
 import (
 	"fmt"
 	"net/http"
-	"regexp"
-	"sync"
+	"time"
 
+	"github.com/lisa/k8s-webhook-framework/pkg/audit"
+	"github.com/lisa/k8s-webhook-framework/pkg/authz"
+	"github.com/lisa/k8s-webhook-framework/pkg/enforcement"
 	responsehelper "github.com/lisa/k8s-webhook-framework/pkg/helpers"
+	"github.com/lisa/k8s-webhook-framework/pkg/metrics"
+	"github.com/lisa/k8s-webhook-framework/pkg/policy"
 	"github.com/lisa/k8s-webhook-framework/pkg/webhooks/utils"
 	"k8s.io/api/admission/v1beta1"
 	admissionregv1 "k8s.io/api/admissionregistration/v1"
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	admissionctl "sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 
@@ -18,21 +23,10 @@ import (
 )
 
 const (
-	WebhookName                  string = "namespace-validation"
-	privilegedNamespace          string = `(^kube.*|^openshift.*|^default$|^redhat.*)`
-	privilegedServiceAccounts    string = `^system:serviceaccounts:(kube.*|openshift.*|default|redhat.*)`
-	layeredProductNamespace      string = `^redhat.*`
-	layeredProductAdminGroupName string = "layered-sre-cluster-admins"
+	WebhookName string = "namespace-validation"
 )
 
 var (
-	clusterAdminUsers = []string{"kube:admin", "system:admin"}
-	sreAdminGroups    = []string{"osd-sre-admins", "osd-sre-cluster-admins"}
-
-	privilegedNamespaceRe       = regexp.MustCompile(privilegedNamespace)
-	privilegedServiceAccountsRe = regexp.MustCompile(privilegedServiceAccounts)
-	layeredProductNamespaceRe   = regexp.MustCompile(layeredProductNamespace)
-
 	log = logf.Log.WithName(WebhookName)
 
 	sideEffects = admissionregv1.SideEffectClassNone
@@ -51,15 +45,25 @@ var (
 	}
 )
 
-// NamespaceWebhook validates a Namespace change
+// NamespaceWebhook validates a Namespace change. It is safe for concurrent
+// use.
 type NamespaceWebhook struct {
-	mu sync.Mutex
-	s  runtime.Scheme
+	s       *runtime.Scheme
+	decoder *admissionctl.Decoder
 }
 
 func (s *NamespaceWebhook) TimeoutSeconds() int32                        { return 2 }
 func (s *NamespaceWebhook) SideEffects() *admissionregv1.SideEffectClass { return &sideEffects }
 func (s *NamespaceWebhook) MatchPolicy() *admissionregv1.MatchPolicyType { return &matchPolicy }
+
+// NamespaceSelector is nil: for Namespaces the API server matches it against
+// the Namespace's own labels, which the requester sets, so any selector would
+// let them exempt a privileged namespace from this hook.
+func (s *NamespaceWebhook) NamespaceSelector() *metav1.LabelSelector { return nil }
+
+// ObjectSelector is nil: for the same reason as NamespaceSelector.
+func (s *NamespaceWebhook) ObjectSelector() *metav1.LabelSelector { return nil }
+
 func (s *NamespaceWebhook) Rules() []admissionregv1.RuleWithOperations {
 	return rules
 }
@@ -88,15 +92,12 @@ func (s *NamespaceWebhook) GetURI() string {
 
 // renderNamespace pluck out the Namespace from the Object or OldObject
 func (s *NamespaceWebhook) renderNamespace(req admissionctl.Request) (*corev1.Namespace, error) {
-	decoder, err := admissionctl.NewDecoder(&s.s)
-	if err != nil {
-		return nil, err
-	}
+	var err error
 	namespace := &corev1.Namespace{}
 	if len(req.OldObject.Raw) > 0 {
-		err = decoder.DecodeRaw(req.OldObject, namespace)
+		err = s.decoder.DecodeRaw(req.OldObject, namespace)
 	} else {
-		err = decoder.Decode(req, namespace)
+		err = s.decoder.Decode(req, namespace)
 	}
 	if err != nil {
 		return nil, err
@@ -106,78 +107,61 @@ func (s *NamespaceWebhook) renderNamespace(req admissionctl.Request) (*corev1.Na
 
 // Is the request authorized?
 func (s *NamespaceWebhook) authorized(request admissionctl.Request) admissionctl.Response {
-	var ret admissionctl.Response
+	p := policy.Current()
 	ns, err := s.renderNamespace(request)
 	if err != nil {
 		log.Error(err, "Couldn't render a Namespace from the incoming request")
-		return admissionctl.Errored(http.StatusBadRequest, err)
-	}
-	// L49-L56
-	// service accounts making requests will include their name in the group
-	for _, group := range request.UserInfo.Groups {
-		if privilegedServiceAccountsRe.Match([]byte(group)) {
-			ret = admissionctl.Allowed("Privileged service accounts may access")
-			ret.UID = request.AdmissionRequest.UID
-			return ret
-		}
-	}
-	// L58-L62
-	// This must be prior to privileged namespace check
-	if utils.SliceContains(layeredProductAdminGroupName, request.UserInfo.Groups) &&
-		layeredProductNamespaceRe.Match([]byte(ns.GetName())) {
-		ret = admissionctl.Allowed("Layered product admins may access")
+		ret := admissionctl.Errored(http.StatusBadRequest, err)
 		ret.UID = request.AdmissionRequest.UID
 		return ret
 	}
-	// L64-73
-	if privilegedNamespaceRe.Match([]byte(ns.GetName())) {
-		amISREAdmin := false
-		amIClusterAdmin := utils.SliceContains(request.UserInfo.Username, clusterAdminUsers)
-
-		for _, group := range sreAdminGroups {
-			if utils.SliceContains(group, request.UserInfo.Groups) {
-				amISREAdmin = true
-				break
-			}
-		}
-		if amIClusterAdmin || amISREAdmin {
-			ret = admissionctl.Allowed("Cluster and SRE admins may access")
-			ret.UID = request.AdmissionRequest.UID
-			return ret
-		}
-		ret = admissionctl.Denied("Non-admin access attempt to privileged namespace")
-		ret.UID = request.AdmissionRequest.UID
-		return ret
-	}
-	// L75-L77
-	ret = admissionctl.Allowed("RBAC allowed")
-	ret.UID = request.AdmissionRequest.UID
-	return ret
+	privileged := authz.If(p.IsPrivilegedNamespace(ns.GetName()))
+	decision := authz.Chain{
+		// L49-L56
+		// service accounts making requests will include their name in the group
+		authz.Allow("privileged-service-account", p.PrivilegedServiceAccount(), "Privileged service accounts may access"),
+		// L58-L62
+		// This must be prior to privileged namespace check
+		authz.Allow("layered-product-admin",
+			authz.All(p.LayeredProductAdmin(), authz.If(p.IsLayeredProductNamespace(ns.GetName()))),
+			"Layered product admins may access"),
+		// L64-73
+		authz.Allow("privileged-namespace-admin",
+			authz.All(privileged, authz.Any(p.ClusterAdmin(), p.SREAdmin())),
+			"Cluster and SRE admins may access"),
+		authz.Deny("privileged-namespace", privileged, "Non-admin access attempt to privileged namespace"),
+		// L75-L77
+		authz.Allow("rbac", authz.Always(), "RBAC allowed"),
+	}.Evaluate(request.AdmissionRequest.UserInfo)
+	return audit.WithRule(decision.Response(request), decision.Rule)
 }
 
 // HandleRequest Decide if the incoming request is allowed
 // Based on https://github.com/openshift/managed-cluster-validating-webhooks/blob/ad1ecb38621c485b5832eea729244e3b5ef354cc/src/webhook/namespace_validation.py
 func (s *NamespaceWebhook) HandleRequest(w http.ResponseWriter, r *http.Request) {
-
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	request, _, err := utils.ParseHTTPRequest(r)
+	start := time.Now()
+	request, version, err := utils.ParseHTTPRequest(r)
 	if err != nil {
 		log.Error(err, "Error parsing HTTP Request Body")
-		responsehelper.SendResponse(w, admissionctl.Errored(http.StatusBadRequest, err))
+		metrics.RecordDecodeFailure(WebhookName)
+		responsehelper.SendResponse(w, admissionctl.Errored(http.StatusBadRequest, err), version)
 		return
 	}
 	// Is this a valid request?
 	if !s.Validate(request) {
-		responsehelper.SendResponse(w,
-			admissionctl.Errored(http.StatusBadRequest,
-				fmt.Errorf("Could not parse Namespace from request")))
+		resp := admissionctl.Errored(http.StatusBadRequest,
+			fmt.Errorf("Could not parse Namespace from request"))
+		resp.UID = request.AdmissionRequest.UID
+		metrics.RecordResponse(WebhookName, request, resp, start)
+		audit.Record(WebhookName, request, resp)
+		responsehelper.SendResponse(w, resp, version)
 		return
 	}
 	// should the request be authorized?
-
-	responsehelper.SendResponse(w, s.authorized(request))
-
+	resp, warnings := enforcement.Apply(WebhookName, s.authorized(request))
+	metrics.RecordResponse(WebhookName, request, resp, start)
+	audit.Record(WebhookName, request, resp)
+	responsehelper.SendResponse(w, resp, version, warnings...)
 }
 
 // NewWebhook creates a new webhook
@@ -185,8 +169,13 @@ func NewWebhook() *NamespaceWebhook {
 	scheme := runtime.NewScheme()
 	v1beta1.AddToScheme(scheme)
 	corev1.AddToScheme(scheme)
+	decoder, err := admissionctl.NewDecoder(scheme)
+	if err != nil {
+		panic(err.Error())
+	}
 
 	return &NamespaceWebhook{
-		s: *scheme,
+		s:       scheme,
+		decoder: decoder,
 	}
 }
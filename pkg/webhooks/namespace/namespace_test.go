@@ -275,6 +275,12 @@ func TestAdminUser(t *testing.T) {
 func TestBadRequests(t *testing.T) {
 	t.Skip()
 }
+
+// TestFixtures replays the captured AdmissionReviews in fixtures/namespaces
+func TestFixtures(t *testing.T) {
+	testutils.RunFixtures(t, NewWebhook(), "../../../fixtures/namespaces")
+}
+
 func TestMatchPollicy(t *testing.T) {
 	if NewWebhook().MatchPolicy() == nil {
 		t.Fatalf("nil Match Policy")
@@ -303,3 +309,25 @@ func TestSideEffects(t *testing.T) {
 		t.Fatalf("nil side effects")
 	}
 }
+
+func BenchmarkNamespaceWebhook(b *testing.B) {
+	gvk := metav1.GroupVersionKind{
+		Group:   "",
+		Version: "v1",
+		Kind:    "Namespace",
+	}
+	gvr := metav1.GroupVersionResource{
+		Group:    "",
+		Version:  "v1",
+		Resource: "namespaces",
+	}
+	obj := runtime.RawExtension{
+		Raw: []byte(fmt.Sprintf(testNamespaceRaw, "openshift-test-namespace", "bench-update-priv-ns")),
+	}
+	body, err := testutils.CreateFakeRequestJSON("bench-update-priv-ns", gvk, gvr, v1beta1.Update,
+		"test-user", []string{"dedicated-admins", "system:authenticated", "system:authenticated:oauth"}, obj)
+	if err != nil {
+		b.Fatalf("Expected no error, got %s", err.Error())
+	}
+	testutils.BenchmarkWebhook(b, NewWebhook(), body)
+}
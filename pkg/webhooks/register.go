@@ -4,6 +4,7 @@ import (
 	"net/http"
 
 	admissionregv1 "k8s.io/api/admissionregistration/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	admissionctl "sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 )
 
@@ -26,6 +27,16 @@ type Webhook interface {
 	// If it is important to the webhook, be sure to check subResource vs
 	// requestSubResource.
 	MatchPolicy() *admissionregv1.MatchPolicyType
+	// NamespaceSelector mirrors validatingwebhookconfiguration.webhooks[].namespaceSelector:
+	// requests for objects in namespaces whose labels don't match it are not
+	// sent to the hook. nil matches every namespace.
+	NamespaceSelector() *metav1.LabelSelector
+	// ObjectSelector mirrors validatingwebhookconfiguration.webhooks[].objectSelector:
+	// requests are only sent to the hook if the labels of the object or of the
+	// old object match it. nil matches every object. Whoever makes the
+	// request sets the object's labels, so it must not be used to exempt
+	// objects from a hook enforcing policy on them.
+	ObjectSelector() *metav1.LabelSelector
 	// Rules is a slice of rules on which this hook should trigger
 	Rules() []admissionregv1.RuleWithOperations
 	// SideEffects are what side effects, if any, this hook has. Refer to
@@ -35,6 +46,16 @@ type Webhook interface {
 	TimeoutSeconds() int32
 }
 
+// MutatingWebhook is a Webhook which may modify the objects it admits by
+// returning JSONPatch responses (see admissionctl.PatchResponseFromRaw). Hooks
+// implementing it are registered with a MutatingWebhookConfiguration instead
+// of a ValidatingWebhookConfiguration.
+type MutatingWebhook interface {
+	Webhook
+	// ReinvocationPolicy mirrors mutatingwebhookconfiguration.webhooks[].reinvocationPolicy
+	ReinvocationPolicy() *admissionregv1.ReinvocationPolicyType
+}
+
 // WebhookFactory return a kind of Webhook
 type WebhookFactory func() Webhook
 
@@ -0,0 +1,139 @@
+package regopolicy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/open-policy-agent/opa/rego"
+	admissionregv1 "k8s.io/api/admissionregistration/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// ConfigFile is the file in a policy directory which configures the
+	// webhook serving it
+	ConfigFile string = "webhook.yaml"
+	// Query is what is evaluated against each AdmissionReview: the document of
+	// the admission package, whose deny set and allow rule decide requests
+	Query string = "data.admission"
+	// TestSuffix ends the names of modules holding policy unit tests, which are
+	// run with the Go tests rather than served
+	TestSuffix string = "_test.rego"
+
+	// defaultName is the name of the webhook if ConfigFile doesn't set one
+	defaultName string = "rego-validation"
+	// defaultTimeoutSeconds is the TimeoutSeconds of the webhook if ConfigFile
+	// doesn't set one, the same as the built-in hooks'
+	defaultTimeoutSeconds int32 = 2
+)
+
+// nameRe matches names which make valid URIs and webhook names
+var nameRe = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
+// Config configures the webhook serving a directory of Rego modules. Unlike
+// the modules, it is only read at startup, as it ends up in the webhook
+// configuration.
+type Config struct {
+	// Name is the name of the webhook, served at /<name>. It defaults to
+	// rego-validation.
+	Name string `json:"name,omitempty"`
+	// Rules are the requests the API server sends to the webhook
+	Rules []admissionregv1.RuleWithOperations `json:"rules"`
+	// MatchPolicy defaults to Exact
+	MatchPolicy *admissionregv1.MatchPolicyType `json:"matchPolicy,omitempty"`
+	// FailurePolicy defaults to Fail, as policies typically restrict access
+	FailurePolicy *admissionregv1.FailurePolicyType `json:"failurePolicy,omitempty"`
+	// TimeoutSeconds defaults to 2
+	TimeoutSeconds int32 `json:"timeoutSeconds,omitempty"`
+	// NamespaceSelector and ObjectSelector narrow the requests sent to the
+	// webhook, see webhooks.Webhook
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	ObjectSelector    *metav1.LabelSelector `json:"objectSelector,omitempty"`
+}
+
+// LoadConfig reads the ConfigFile of the policy directory dir. Unknown
+// fields, invalid names and missing rules are errors.
+func LoadConfig(dir string) (*Config, error) {
+	path := filepath.Join(dir, ConfigFile)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := yaml.YAMLToJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %s", path, err.Error())
+	}
+	c := &Config{}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(c); err != nil {
+		return nil, fmt.Errorf("invalid %s: %s", path, err.Error())
+	}
+	if err := c.validate(); err != nil {
+		return nil, fmt.Errorf("invalid %s: %s", path, err.Error())
+	}
+	return c, nil
+}
+
+// validate checks c and fills in its defaults
+func (c *Config) validate() error {
+	if c.Name == "" {
+		c.Name = defaultName
+	}
+	if !nameRe.MatchString(c.Name) {
+		return fmt.Errorf("name %q must consist of lower case alphanumeric characters or '-'", c.Name)
+	}
+	if len(c.Rules) == 0 {
+		return fmt.Errorf("webhook %s has no rules", c.Name)
+	}
+	if c.MatchPolicy == nil {
+		exact := admissionregv1.Exact
+		c.MatchPolicy = &exact
+	}
+	if c.FailurePolicy == nil {
+		fail := admissionregv1.Fail
+		c.FailurePolicy = &fail
+	}
+	if c.TimeoutSeconds == 0 {
+		c.TimeoutSeconds = defaultTimeoutSeconds
+	}
+	for _, selector := range []*metav1.LabelSelector{c.NamespaceSelector, c.ObjectSelector} {
+		if _, err := metav1.LabelSelectorAsSelector(selector); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// onlyModules keeps data files, such as ConfigFile, and tests from being
+// loaded with the served modules. When dir is a ConfigMap volume the kubelet
+// keeps the real files in a ..<timestamp> directory, linked from ..data and
+// then from dir, so its ..-prefixed directories are skipped to load each
+// module once.
+func onlyModules(abspath string, info os.FileInfo, depth int) bool {
+	if info.IsDir() {
+		return depth > 0 && strings.HasPrefix(info.Name(), "..")
+	}
+	return !strings.HasSuffix(info.Name(), ".rego") || strings.HasSuffix(info.Name(), TestSuffix)
+}
+
+// prepare compiles the Rego modules in dir into Query, ready to evaluate. A
+// module which doesn't parse or compile is an error.
+func prepare(dir string) (*rego.PreparedEvalQuery, error) {
+	query, err := rego.New(
+		rego.Query(Query),
+		rego.Load([]string{dir}, onlyModules),
+	).PrepareForEval(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("invalid Rego policies in %s: %s", dir, err.Error())
+	}
+	return &query, nil
+}
@@ -0,0 +1,243 @@
+package regopolicy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/lisa/k8s-webhook-framework/pkg/audit"
+	"github.com/lisa/k8s-webhook-framework/pkg/enforcement"
+	responsehelper "github.com/lisa/k8s-webhook-framework/pkg/helpers"
+	"github.com/lisa/k8s-webhook-framework/pkg/metrics"
+	"github.com/lisa/k8s-webhook-framework/pkg/webhooks/utils"
+	"github.com/open-policy-agent/opa/rego"
+	admissionregv1 "k8s.io/api/admissionregistration/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+	admissionctl "sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+var (
+	log = logf.Log.WithName("rego-policy")
+
+	sideEffects = admissionregv1.SideEffectClassNone
+)
+
+// RegoWebhook validates requests with the Rego modules of a directory,
+// evaluating Query with the AdmissionReview as input. A request is denied
+// with the messages of the admission package's deny set, if it has any, or
+// if the package has an allow rule which isn't true; use default allow =
+// false for policies which deny unless allowed. It is safe for concurrent
+// use.
+type RegoWebhook struct {
+	dir    string
+	config *Config
+	// query holds the *rego.PreparedEvalQuery in effect
+	query atomic.Value
+}
+
+func (s *RegoWebhook) TimeoutSeconds() int32                        { return s.config.TimeoutSeconds }
+func (s *RegoWebhook) SideEffects() *admissionregv1.SideEffectClass { return &sideEffects }
+func (s *RegoWebhook) MatchPolicy() *admissionregv1.MatchPolicyType { return s.config.MatchPolicy }
+func (s *RegoWebhook) NamespaceSelector() *metav1.LabelSelector     { return s.config.NamespaceSelector }
+func (s *RegoWebhook) ObjectSelector() *metav1.LabelSelector        { return s.config.ObjectSelector }
+func (s *RegoWebhook) Rules() []admissionregv1.RuleWithOperations {
+	return s.config.Rules
+}
+
+func (s *RegoWebhook) FailurePolicy() admissionregv1.FailurePolicyType {
+	return *s.config.FailurePolicy
+}
+
+func (s *RegoWebhook) Name() string {
+	return s.config.Name
+}
+
+// GetURI - where am I?
+func (s *RegoWebhook) GetURI() string {
+	return "/" + s.config.Name
+}
+
+// Validate - Make sure we're working with a well-formed Admission Request object
+func (s *RegoWebhook) Validate(req admissionctl.Request) bool {
+	return req.UserInfo.Username != ""
+}
+
+// input returns the AdmissionReview of request in its JSON form, which is what
+// policies written for OPA expect. Only its request is filled in.
+func input(request admissionctl.Request) (interface{}, error) {
+	raw, err := json.Marshal(request.AdmissionRequest)
+	if err != nil {
+		return nil, err
+	}
+	var req interface{}
+	if err := json.Unmarshal(raw, &req); err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"kind":    "AdmissionReview",
+		"request": req,
+	}, nil
+}
+
+// decide turns the admission document the policies evaluated to into a
+// response
+func decide(results rego.ResultSet) (admissionctl.Response, error) {
+	if len(results) == 0 || len(results[0].Expressions) == 0 {
+		return admissionctl.Response{}, fmt.Errorf("no policies in package admission")
+	}
+	doc, ok := results[0].Expressions[0].Value.(map[string]interface{})
+	if !ok {
+		return admissionctl.Response{}, fmt.Errorf("expected %s to be an object, got %T", Query, results[0].Expressions[0].Value)
+	}
+
+	if deny, ok := doc["deny"]; ok {
+		set, ok := deny.([]interface{})
+		if !ok {
+			return admissionctl.Response{}, fmt.Errorf("expected deny to be a set, got %T", deny)
+		}
+		if len(set) > 0 {
+			messages := make([]string, 0, len(set))
+			for _, message := range set {
+				messages = append(messages, fmt.Sprint(message))
+			}
+			sort.Strings(messages)
+			return audit.WithRule(admissionctl.Denied(strings.Join(messages, "; ")), "deny"), nil
+		}
+	}
+	if allow, ok := doc["allow"]; ok {
+		allowed, ok := allow.(bool)
+		if !ok {
+			return admissionctl.Response{}, fmt.Errorf("expected allow to be a boolean, got %T", allow)
+		}
+		if !allowed {
+			return audit.WithRule(admissionctl.Denied("Not allowed by policy"), "allow"), nil
+		}
+		return audit.WithRule(admissionctl.Allowed("Allowed by policy"), "allow"), nil
+	}
+	return audit.WithRule(admissionctl.Allowed("Not denied by policy"), "deny"), nil
+}
+
+// Is the request authorized?
+func (s *RegoWebhook) authorized(request admissionctl.Request) admissionctl.Response {
+	var ret admissionctl.Response
+	in, err := input(request)
+	if err != nil {
+		ret = admissionctl.Errored(http.StatusBadRequest, err)
+		ret.UID = request.AdmissionRequest.UID
+		return ret
+	}
+	// The API server gives up on the hook after its timeout anyway
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(s.config.TimeoutSeconds)*time.Second)
+	defer cancel()
+	query := s.query.Load().(*rego.PreparedEvalQuery)
+	results, err := query.Eval(ctx, rego.EvalInput(in))
+	if err == nil {
+		ret, err = decide(results)
+	}
+	if err != nil {
+		log.Error(err, "Couldn't evaluate policies", "webhookName", s.config.Name)
+		ret = admissionctl.Errored(http.StatusInternalServerError, fmt.Errorf("couldn't evaluate policies: %s", err.Error()))
+	}
+	ret.UID = request.AdmissionRequest.UID
+	return ret
+}
+
+// HandleRequest Decide if the incoming request is allowed
+func (s *RegoWebhook) HandleRequest(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	request, version, err := utils.ParseHTTPRequest(r)
+	if err != nil {
+		log.Error(err, "Error parsing HTTP Request Body", "webhookName", s.config.Name)
+		metrics.RecordDecodeFailure(s.config.Name)
+		responsehelper.SendResponse(w, admissionctl.Errored(http.StatusBadRequest, err), version)
+		return
+	}
+	// Is this a valid request?
+	if !s.Validate(request) {
+		resp := admissionctl.Errored(http.StatusBadRequest, fmt.Errorf("Could not parse request"))
+		resp.UID = request.AdmissionRequest.UID
+		metrics.RecordResponse(s.config.Name, request, resp, start)
+		audit.Record(s.config.Name, request, resp)
+		responsehelper.SendResponse(w, resp, version)
+		return
+	}
+	// should the request be authorized?
+	resp, warnings := enforcement.Apply(s.config.Name, s.authorized(request))
+	metrics.RecordResponse(s.config.Name, request, resp, start)
+	audit.Record(s.config.Name, request, resp)
+	responsehelper.SendResponse(w, resp, version, warnings...)
+}
+
+// reload compiles the modules in the directory again and puts them into
+// effect. Modules which fail to compile leave the previous ones in effect.
+func (s *RegoWebhook) reload() {
+	query, err := prepare(s.dir)
+	if err != nil {
+		log.Error(err, "Couldn't reload Rego policies, continuing to use the previous ones", "dir", s.dir)
+		return
+	}
+	s.query.Store(query)
+	log.Info("Rego policies reloaded", "dir", s.dir)
+}
+
+// Start reloads the Rego modules whenever the directory changes, until stop
+// is closed. Like the policy file's, the directory is watched so that
+// ConfigMap updates, which swap a symlink, are noticed. Subdirectories
+// aren't.
+func (s *RegoWebhook) Start(stop <-chan struct{}) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+	if err := watcher.Add(s.dir); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-stop:
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			// Chmod events are too noisy and never change the contents
+			if event.Op == fsnotify.Chmod {
+				continue
+			}
+			s.reload()
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			log.Error(err, "Error watching Rego policies")
+		}
+	}
+}
+
+// NewWebhook creates a new webhook serving the Rego modules in dir, as
+// configured by its ConfigFile. It is an error if either doesn't load.
+func NewWebhook(dir string) (*RegoWebhook, error) {
+	config, err := LoadConfig(dir)
+	if err != nil {
+		return nil, err
+	}
+	query, err := prepare(dir)
+	if err != nil {
+		return nil, err
+	}
+	s := &RegoWebhook{
+		dir:    dir,
+		config: config,
+	}
+	s.query.Store(query)
+	return s, nil
+}
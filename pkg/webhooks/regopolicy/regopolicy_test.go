@@ -0,0 +1,255 @@
+package regopolicy
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lisa/k8s-webhook-framework/pkg/testutils"
+	"github.com/open-policy-agent/opa/tester"
+
+	"k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+const (
+	fixtures = "../../../fixtures/rego-policies"
+	policies = fixtures + "/policies"
+
+	config = `
+name: test-policy
+rules:
+- operations: ["*"]
+  apiGroups: [""]
+  apiVersions: ["*"]
+  resources: ["configmaps"]
+`
+)
+
+func TestFixtures(t *testing.T) {
+	hook, err := NewWebhook(policies)
+	if err != nil {
+		t.Fatalf("Couldn't load policies: %s", err.Error())
+	}
+	testutils.RunFixtures(t, hook, fixtures)
+}
+
+// TestRegoUnitTests runs the policies' own unit tests, so they fail the build
+// like the Go ones
+func TestRegoUnitTests(t *testing.T) {
+	results, err := tester.Run(context.Background(), policies)
+	if err != nil {
+		t.Fatalf("Couldn't run Rego tests: %s", err.Error())
+	}
+	if len(results) == 0 {
+		t.Fatalf("No Rego tests found in %s", policies)
+	}
+	for _, result := range results {
+		if result.Error != nil {
+			t.Errorf("%s.%s: %s", result.Package, result.Name, result.Error.Error())
+		} else if result.Fail {
+			t.Errorf("%s.%s failed", result.Package, result.Name)
+		}
+	}
+}
+
+// writePolicies writes config and module into a new directory
+func writePolicies(t *testing.T, config, module string) string {
+	dir, err := ioutil.TempDir("", "regopolicy")
+	if err != nil {
+		t.Fatalf("Couldn't create temporary directory: %s", err.Error())
+	}
+	writeFile(t, filepath.Join(dir, ConfigFile), config)
+	writeFile(t, filepath.Join(dir, "admission.rego"), module)
+	return dir
+}
+
+// writeConfigMapPolicies writes config and module into a new directory laid
+// out the way the kubelet mounts a ConfigMap: the files live in a timestamped
+// directory, linked from ..data, which the top level files link through
+func writeConfigMapPolicies(t *testing.T, config, module string) string {
+	dir, err := ioutil.TempDir("", "regopolicy")
+	if err != nil {
+		t.Fatalf("Couldn't create temporary directory: %s", err.Error())
+	}
+	data := "..2020_06_01_12_00_00.000000001"
+	if err := os.Mkdir(filepath.Join(dir, data), 0755); err != nil {
+		t.Fatalf("Couldn't create %s: %s", data, err.Error())
+	}
+	writeFile(t, filepath.Join(dir, data, ConfigFile), config)
+	writeFile(t, filepath.Join(dir, data, "admission.rego"), module)
+	if err := os.Symlink(data, filepath.Join(dir, "..data")); err != nil {
+		t.Fatalf("Couldn't link ..data: %s", err.Error())
+	}
+	for _, name := range []string{ConfigFile, "admission.rego"} {
+		if err := os.Symlink(filepath.Join("..data", name), filepath.Join(dir, name)); err != nil {
+			t.Fatalf("Couldn't link %s: %s", name, err.Error())
+		}
+	}
+	return dir
+}
+
+func writeFile(t *testing.T, path, content string) {
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Couldn't write %s: %s", path, err.Error())
+	}
+}
+
+func TestNewWebhook(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      string
+		module      string
+		expectedErr string
+	}{
+		{
+			name:   "valid",
+			config: config,
+			module: "package admission\n\ndeny[msg] { msg := \"no\" }\n",
+		},
+		{
+			name:        "syntax error",
+			config:      config,
+			module:      "package admission\n\ndeny[msg] {\n",
+			expectedErr: "invalid Rego policies",
+		},
+		{
+			name:        "unknown field",
+			config:      strings.Replace(config, "rules:", "rule:", 1),
+			module:      "package admission\n",
+			expectedErr: "unknown field",
+		},
+		{
+			name:        "invalid name",
+			config:      strings.Replace(config, "test-policy", "Test/Policy", 1),
+			module:      "package admission\n",
+			expectedErr: "name",
+		},
+		{
+			name:        "no rules",
+			config:      "name: test-policy\n",
+			module:      "package admission\n",
+			expectedErr: "no rules",
+		},
+	}
+	for _, test := range tests {
+		dir := writePolicies(t, test.config, test.module)
+		defer os.RemoveAll(dir)
+		hook, err := NewWebhook(dir)
+		if test.expectedErr == "" {
+			if err != nil {
+				t.Fatalf("%s: unexpected error: %s", test.name, err.Error())
+			}
+			if hook.FailurePolicy() != "Fail" || *hook.MatchPolicy() != "Exact" || hook.TimeoutSeconds() != defaultTimeoutSeconds {
+				t.Fatalf("%s: expected defaults to be filled in, got %+v", test.name, hook.config)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), test.expectedErr) {
+			t.Fatalf("%s: expected an error containing %q, got %v", test.name, test.expectedErr, err)
+		}
+	}
+}
+
+func TestConfigMapVolume(t *testing.T) {
+	// A default rule is rejected if the module is loaded more than once
+	dir := writeConfigMapPolicies(t, config, "package admission\n\ndefault allow = false\n\nallow { input.request.userInfo.username == \"test-user\" }\n")
+	defer os.RemoveAll(dir)
+	hook, err := NewWebhook(dir)
+	if err != nil {
+		t.Fatalf("Couldn't load policies: %s", err.Error())
+	}
+	if got := code(t, hook); got != http.StatusOK {
+		t.Fatalf("Expected a %d response, got %d", http.StatusOK, got)
+	}
+}
+
+// code returns the status code of hook's response to a ConfigMap being created
+func code(t *testing.T, hook *RegoWebhook) int32 {
+	gvk := metav1.GroupVersionKind{Group: "", Version: "v1", Kind: "ConfigMap"}
+	gvr := metav1.GroupVersionResource{Group: "", Version: "v1", Resource: "configmaps"}
+	obj := runtime.RawExtension{Raw: []byte(`{"metadata": {"name": "settings"}}`)}
+	httprequest, err := testutils.CreateHTTPRequest(hook.GetURI(), "abcd-123", gvk, gvr, v1beta1.Create, "test-user", []string{}, obj)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err.Error())
+	}
+	response, err := testutils.SendHTTPRequest(httprequest, hook)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err.Error())
+	}
+	if response.UID == "" {
+		t.Fatalf("No tracking UID associated with the response.")
+	}
+	if response.Result == nil {
+		t.Fatalf("No result in the response")
+	}
+	return response.Result.Code
+}
+
+func TestEvaluation(t *testing.T) {
+	tests := []struct {
+		module       string
+		expectedCode int32
+	}{
+		{module: `deny[msg] { input.request.object.metadata.name == "settings"; msg := "no settings" }`, expectedCode: http.StatusForbidden},
+		{module: `deny[msg] { input.request.object.metadata.name == "other"; msg := "no other" }`, expectedCode: http.StatusOK},
+		{module: `default allow = false`, expectedCode: http.StatusForbidden},
+		{module: `allow { input.request.userInfo.username == "test-user" }`, expectedCode: http.StatusOK},
+		// deny wins over allow
+		{module: "allow = true\ndeny[\"no\"] { true }", expectedCode: http.StatusForbidden},
+		// The decision must be made of a set and a boolean
+		{module: `allow = "yes"`, expectedCode: http.StatusInternalServerError},
+	}
+	for _, test := range tests {
+		dir := writePolicies(t, config, "package admission\n\n"+test.module+"\n")
+		defer os.RemoveAll(dir)
+		hook, err := NewWebhook(dir)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", test.module, err.Error())
+		}
+		if got := code(t, hook); got != test.expectedCode {
+			t.Fatalf("%s: expected a %d response, got %d", test.module, test.expectedCode, got)
+		}
+	}
+}
+
+func TestReload(t *testing.T) {
+	dir := writePolicies(t, config, "package admission\n\ndeny[\"no\"] { true }\n")
+	defer os.RemoveAll(dir)
+	hook, err := NewWebhook(dir)
+	if err != nil {
+		t.Fatalf("Couldn't load policies: %s", err.Error())
+	}
+	if got := code(t, hook); got != http.StatusForbidden {
+		t.Fatalf("Expected a %d response, got %d", http.StatusForbidden, got)
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		if err := hook.Start(stop); err != nil {
+			t.Errorf("Couldn't watch policies: %s", err.Error())
+		}
+	}()
+
+	// A module which doesn't compile leaves the previous ones in effect
+	writeFile(t, filepath.Join(dir, "admission.rego"), "package admission\n\ndeny[msg] {\n")
+	time.Sleep(100 * time.Millisecond)
+	if got := code(t, hook); got != http.StatusForbidden {
+		t.Fatalf("Expected the previous policy to still deny, got %d", got)
+	}
+
+	writeFile(t, filepath.Join(dir, "admission.rego"), "package admission\n\ndeny[\"no\"] { false }\n")
+	deadline := time.Now().Add(5 * time.Second)
+	for code(t, hook) != http.StatusOK {
+		if time.Now().After(deadline) {
+			t.Fatalf("Policies weren't reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -3,14 +3,20 @@ package regularuser
 import (
 	"fmt"
 	"net/http"
-	"strings"
-	"sync"
+	"regexp"
+	"time"
 
+	"github.com/lisa/k8s-webhook-framework/pkg/audit"
+	"github.com/lisa/k8s-webhook-framework/pkg/authz"
+	"github.com/lisa/k8s-webhook-framework/pkg/enforcement"
 	responsehelper "github.com/lisa/k8s-webhook-framework/pkg/helpers"
+	"github.com/lisa/k8s-webhook-framework/pkg/metrics"
+	"github.com/lisa/k8s-webhook-framework/pkg/policy"
 	"github.com/lisa/k8s-webhook-framework/pkg/webhooks/utils"
 	"k8s.io/api/admission/v1beta1"
 	admissionregv1 "k8s.io/api/admissionregistration/v1"
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	admissionctl "sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 
@@ -19,11 +25,11 @@ import (
 
 const (
 	WebhookName string = "regular-user-validation"
+
+	unauthenticatedUser string = "system:unauthenticated"
 )
 
 var (
-	adminGroups = []string{"osd-sre-admins", "osd-sre-cluster-admins"}
-
 	sideEffects = admissionregv1.SideEffectClassNone
 	matchPolicy = admissionregv1.Equivalent
 	scope       = admissionregv1.AllScopes
@@ -73,18 +79,33 @@ var (
 		},
 	}
 	log = logf.Log.WithName(WebhookName)
+
+	// kubeUserRe matches users of the cluster's own identity provider, such
+	// as kube:admin
+	kubeUserRe = regexp.MustCompile(`^kube:`)
 )
 
-// NamespaceWebhook validates a Namespace change
+// RegularuserWebhook restricts changes to cluster infrastructure to admins.
+// It is safe for concurrent use.
 type RegularuserWebhook struct {
-	mu sync.Mutex
-	s  runtime.Scheme
+	s *runtime.Scheme
 }
 
 func (s *RegularuserWebhook) TimeoutSeconds() int32                        { return 2 }
 func (s *RegularuserWebhook) SideEffects() *admissionregv1.SideEffectClass { return &sideEffects }
 func (s *RegularuserWebhook) MatchPolicy() *admissionregv1.MatchPolicyType { return &matchPolicy }
 
+// NamespaceSelector skips customer namespaces: the infrastructure this hook
+// protects lives in privileged namespaces or is cluster scoped, which the
+// selector always lets through.
+func (s *RegularuserWebhook) NamespaceSelector() *metav1.LabelSelector {
+	return utils.PrivilegedNamespaceSelector()
+}
+
+// ObjectSelector is nil: whoever changes an object also sets its labels, so
+// any selector would let them exempt it from this hook.
+func (s *RegularuserWebhook) ObjectSelector() *metav1.LabelSelector { return nil }
+
 // Name what am I called?
 func (s *RegularuserWebhook) Name() string {
 	return WebhookName
@@ -114,57 +135,45 @@ func (s *RegularuserWebhook) GetURI() string {
 }
 
 func (s *RegularuserWebhook) authorized(request admissionctl.Request) admissionctl.Response {
-	var ret admissionctl.Response
-
-	if request.AdmissionRequest.UserInfo.Username == "system:unauthenticated" {
+	decision := authz.Chain{
+		authz.Deny("unauthenticated", authz.UserIn(unauthenticatedUser), "Unauthenticated"),
+		authz.Allow("kube-user", authz.UserMatches(kubeUserRe), ""),
+		authz.Allow("sre-admin", policy.Current().SREAdmin(), ""),
+		authz.Deny("default-deny", authz.Always(), "Denied"),
+	}.Evaluate(request.AdmissionRequest.UserInfo)
+	if decision.Rule == "unauthenticated" {
 		// This could highlight a significant problem with RBAC since an
 		// unauthenticated user should have no permissions.
 		log.Info("system:unauthenticated made a webhook request. Check RBAC rules", "request", request.AdmissionRequest)
-		ret = admissionctl.Denied("Unauthenticated")
-		ret.UID = request.AdmissionRequest.UID
-		return ret
 	}
-	if strings.HasPrefix(request.AdmissionRequest.UserInfo.Username, "kube:") {
-		ret = admissionctl.Allowed("")
-		ret.UID = request.AdmissionRequest.UID
-		return ret
-	}
-	for _, userGroup := range request.UserInfo.Groups {
-		if utils.SliceContains(userGroup, adminGroups) {
-			ret = admissionctl.Allowed("")
-			ret.UID = request.AdmissionRequest.UID
-			return ret
-		}
-	}
-
-	ret = admissionctl.Denied("Denied")
-	ret.UID = request.AdmissionRequest.UID
-	return ret
+	return audit.WithRule(decision.Response(request), decision.Rule)
 }
 
 // HandleRequest hndles the incoming HTTP request
 func (s *RegularuserWebhook) HandleRequest(w http.ResponseWriter, r *http.Request) {
-
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	request, _, err := utils.ParseHTTPRequest(r)
+	start := time.Now()
+	request, version, err := utils.ParseHTTPRequest(r)
 	if err != nil {
 		log.Error(err, "Error parsing HTTP Request Body")
-		responsehelper.SendResponse(w, admissionctl.Errored(http.StatusBadRequest, err))
+		metrics.RecordDecodeFailure(WebhookName)
+		responsehelper.SendResponse(w, admissionctl.Errored(http.StatusBadRequest, err), version)
 		return
 	}
 	// Is this a valid request?
 	if !s.Validate(request) {
 		resp := admissionctl.Errored(http.StatusBadRequest, fmt.Errorf("Could not parse Namespace from request"))
 		resp.UID = request.AdmissionRequest.UID
-		responsehelper.SendResponse(w, resp)
+		metrics.RecordResponse(WebhookName, request, resp, start)
+		audit.Record(WebhookName, request, resp)
+		responsehelper.SendResponse(w, resp, version)
 
 		return
 	}
 	// should the request be authorized?
-
-	responsehelper.SendResponse(w, s.authorized(request))
-
+	resp, warnings := enforcement.Apply(WebhookName, s.authorized(request))
+	metrics.RecordResponse(WebhookName, request, resp, start)
+	audit.Record(WebhookName, request, resp)
+	responsehelper.SendResponse(w, resp, version, warnings...)
 }
 
 // NewWebhook creates a new webhook
@@ -174,6 +183,6 @@ func NewWebhook() *RegularuserWebhook {
 	corev1.AddToScheme(scheme)
 
 	return &RegularuserWebhook{
-		s: *scheme,
+		s: scheme,
 	}
 }
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"testing"
 
+	"github.com/lisa/k8s-webhook-framework/pkg/enforcement"
 	"github.com/lisa/k8s-webhook-framework/pkg/testutils"
 
 	"k8s.io/api/admission/v1beta1"
@@ -174,6 +175,38 @@ func TestInvalidRequest(t *testing.T) {
 	runRegularuserTests(t, tests)
 }
 
+// TestWarnMode rolls the hook out without denying anything
+func TestWarnMode(t *testing.T) {
+	enforcement.SetMode(WebhookName, enforcement.Warn)
+	defer enforcement.SetMode(WebhookName, enforcement.Enforce)
+	tests := []regularuserTests{
+		{
+			testID:          "node-unpriv-user-warn",
+			targetResource:  "nodes",
+			targetKind:      "Node",
+			targetVersion:   "v1",
+			targetGroup:     "",
+			username:        "test-user",
+			userGroups:      []string{"system:authenticated", "system:authenticated:oauth"},
+			operation:       v1beta1.Update,
+			shouldBeAllowed: true,
+		},
+		{
+			// Invalid requests are still rejected
+			testID:          "node-no-username-warn",
+			targetResource:  "nodes",
+			targetKind:      "Node",
+			targetVersion:   "v1",
+			targetGroup:     "",
+			username:        "",
+			userGroups:      []string{""},
+			operation:       v1beta1.Delete,
+			shouldBeAllowed: false,
+		},
+	}
+	runRegularuserTests(t, tests)
+}
+
 // These resources follow a similar pattern with a specific Resource is
 // specified, and then some subresources
 func TestNodesSubjectPermissionsClusterVersions(t *testing.T) {
@@ -315,6 +348,11 @@ func TestNodesSubjectPermissionsClusterVersions(t *testing.T) {
 	runRegularuserTests(t, tests)
 }
 
+// TestFixtures replays the captured AdmissionReviews in fixtures/regular-users
+func TestFixtures(t *testing.T) {
+	testutils.RunFixtures(t, NewWebhook(), "../../../fixtures/regular-users")
+}
+
 func TestMatchPollicy(t *testing.T) {
 	if NewWebhook().MatchPolicy() == nil {
 		t.Fatalf("nil Match Policy")
@@ -343,3 +381,25 @@ func TestSideEffects(t *testing.T) {
 		t.Fatalf("nil side effects")
 	}
 }
+
+func BenchmarkRegularuserWebhook(b *testing.B) {
+	gvk := metav1.GroupVersionKind{
+		Group:   "",
+		Version: "v1",
+		Kind:    "Node",
+	}
+	gvr := metav1.GroupVersionResource{
+		Group:    "",
+		Version:  "v1",
+		Resource: "nodes",
+	}
+	obj := runtime.RawExtension{
+		Raw: []byte(fmt.Sprintf(objectStringResource, "Node", "bench-delete-node")),
+	}
+	body, err := testutils.CreateFakeRequestJSON("bench-delete-node", gvk, gvr, v1beta1.Delete,
+		"test-user", []string{"system:authenticated", "system:authenticated:oauth"}, obj)
+	if err != nil {
+		b.Fatalf("Expected no error, got %s", err.Error())
+	}
+	testutils.BenchmarkWebhook(b, NewWebhook(), body)
+}
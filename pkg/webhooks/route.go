@@ -0,0 +1,94 @@
+package webhooks
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+	admissionctl "sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	"github.com/lisa/k8s-webhook-framework/pkg/audit"
+	responsehelper "github.com/lisa/k8s-webhook-framework/pkg/helpers"
+	"github.com/lisa/k8s-webhook-framework/pkg/metrics"
+	"github.com/lisa/k8s-webhook-framework/pkg/webhooks/utils"
+)
+
+// MisroutedAction is what to do with a request sent to a webhook whose rules
+// don't match it, which points at a stale or hand-edited webhook
+// configuration.
+type MisroutedAction string
+
+const (
+	// MisroutedLog logs and counts misrouted requests, then lets the webhook
+	// answer them as usual
+	MisroutedLog MisroutedAction = "log"
+	// MisroutedReject also answers them with an error instead of the webhook
+	MisroutedReject MisroutedAction = "reject"
+)
+
+var log = logf.Log.WithName("webhooks")
+
+// ParseMisroutedAction checks that s names a MisroutedAction.
+func ParseMisroutedAction(s string) (MisroutedAction, error) {
+	switch action := MisroutedAction(s); action {
+	case MisroutedLog, MisroutedReject:
+		return action, nil
+	}
+	return "", fmt.Errorf("unknown misrouted request action %q, expected %s or %s", s, MisroutedLog, MisroutedReject)
+}
+
+// CheckRoute wraps hook's HandleRequest so that requests its Matcher says the
+// API server should not have sent it are handled according to action.
+// Requests which can't be decoded are left for the webhook to answer.
+func CheckRoute(hook Webhook, action MisroutedAction) http.HandlerFunc {
+	matcher := NewMatcher(hook)
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		if r.Body == nil {
+			hook.HandleRequest(w, r)
+			return
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			hook.HandleRequest(w, r)
+			return
+		}
+		// Both copies need the body
+		parse := r.Clone(r.Context())
+		parse.Body = ioutil.NopCloser(bytes.NewReader(body))
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		req, version, err := utils.ParseHTTPRequest(parse)
+		if err != nil {
+			hook.HandleRequest(w, r)
+			return
+		}
+		matched, err := matcher.Matches(req, nil)
+		if err != nil {
+			log.Error(err, "Couldn't check whether the request matches the webhook's rules", "webhookName", hook.Name(), "uid", req.UID)
+			hook.HandleRequest(w, r)
+			return
+		}
+		if matched {
+			hook.HandleRequest(w, r)
+			return
+		}
+
+		log.Info("Received a request the webhook's rules don't match", "webhookName", hook.Name(), "uid", req.UID,
+			"operation", req.Operation, "resource", req.Resource, "subResource", req.SubResource, "namespace", req.Namespace, "action", action)
+		metrics.RecordMisroutedRequest(hook.Name())
+		if action != MisroutedReject {
+			hook.HandleRequest(w, r)
+			return
+		}
+		resp := admissionctl.Errored(http.StatusBadRequest,
+			fmt.Errorf("%s %s is not handled by webhook %s", req.Operation, req.Resource.String(), hook.Name()))
+		resp.UID = req.UID
+		metrics.RecordResponse(hook.Name(), req, resp, start)
+		audit.Record(hook.Name(), req, resp)
+		responsehelper.SendResponse(w, resp, version)
+	}
+}
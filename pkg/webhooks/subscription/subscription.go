@@ -0,0 +1,172 @@
+package subscription
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/lisa/k8s-webhook-framework/pkg/audit"
+	"github.com/lisa/k8s-webhook-framework/pkg/authz"
+	"github.com/lisa/k8s-webhook-framework/pkg/enforcement"
+	responsehelper "github.com/lisa/k8s-webhook-framework/pkg/helpers"
+	"github.com/lisa/k8s-webhook-framework/pkg/metrics"
+	"github.com/lisa/k8s-webhook-framework/pkg/policy"
+	"github.com/lisa/k8s-webhook-framework/pkg/webhooks/utils"
+	"k8s.io/api/admission/v1beta1"
+	admissionregv1 "k8s.io/api/admissionregistration/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	admissionctl "sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+)
+
+const (
+	WebhookName string = "subscription-validation"
+)
+
+var (
+	log = logf.Log.WithName(WebhookName)
+
+	sideEffects = admissionregv1.SideEffectClassNone
+	matchPolicy = admissionregv1.Exact
+	scope       = admissionregv1.NamespacedScope
+	rules       = []admissionregv1.RuleWithOperations{
+		{
+			Operations: []admissionregv1.OperationType{"CREATE"},
+			Rule: admissionregv1.Rule{
+				APIGroups:   []string{"operators.coreos.com"},
+				APIVersions: []string{"*"},
+				Resources:   []string{"subscriptions"},
+				Scope:       &scope,
+			},
+		},
+	}
+)
+
+// subscriptionRequest represents a fragment of the data sent as part as part
+// of the request
+type subscriptionRequest struct {
+	Metadata struct {
+		Name      string `json:"name"`
+		Namespace string `json:"namespace"`
+	} `json:"metadata"`
+}
+
+// SubscriptionWebhook validates the creation of OLM Subscriptions. It is safe
+// for concurrent use.
+type SubscriptionWebhook struct {
+	s *runtime.Scheme
+}
+
+func (s *SubscriptionWebhook) TimeoutSeconds() int32                        { return 2 }
+func (s *SubscriptionWebhook) SideEffects() *admissionregv1.SideEffectClass { return &sideEffects }
+func (s *SubscriptionWebhook) MatchPolicy() *admissionregv1.MatchPolicyType { return &matchPolicy }
+
+// NamespaceSelector skips customer namespaces, where Subscriptions are never
+// denied.
+func (s *SubscriptionWebhook) NamespaceSelector() *metav1.LabelSelector {
+	return utils.PrivilegedNamespaceSelector()
+}
+
+// ObjectSelector is nil: whoever creates a Subscription also sets its labels, so
+// any selector would let them exempt it from this hook.
+func (s *SubscriptionWebhook) ObjectSelector() *metav1.LabelSelector { return nil }
+
+func (s *SubscriptionWebhook) Rules() []admissionregv1.RuleWithOperations {
+	return rules
+}
+
+func (s *SubscriptionWebhook) FailurePolicy() admissionregv1.FailurePolicyType {
+	return admissionregv1.Ignore
+}
+
+func (s *SubscriptionWebhook) Name() string {
+	return WebhookName
+}
+
+// Validate - Make sure we're working with a well-formed Admission Request object
+func (s *SubscriptionWebhook) Validate(req admissionctl.Request) bool {
+	valid := true
+	valid = valid && (req.UserInfo.Username != "")
+	valid = valid && (req.Kind.Kind == "Subscription")
+
+	return valid
+}
+
+// GetURI where am I?
+func (s *SubscriptionWebhook) GetURI() string {
+	return "/subscription-validation"
+}
+
+// Is the request authorized?
+func (s *SubscriptionWebhook) authorized(request admissionctl.Request) admissionctl.Response {
+	var ret admissionctl.Response
+	sub := &subscriptionRequest{}
+	err := json.Unmarshal(request.Object.Raw, sub)
+	if err != nil {
+		ret = admissionctl.Errored(http.StatusBadRequest, err)
+		ret.UID = request.AdmissionRequest.UID
+		return ret
+	}
+	// The API server may not have defaulted the namespace into the object yet
+	namespace := sub.Metadata.Namespace
+	if namespace == "" {
+		namespace = request.AdmissionRequest.Namespace
+	}
+
+	p := policy.Current()
+	decision := authz.Chain{
+		authz.Allow("cluster-admin", p.ClusterAdmin(), "Cluster admins may access"),
+		authz.Allow("sre-admin", p.SREAdmin(), "SRE admins may access"),
+		// Same notion of privileged namespace as the namespace-validation hook.
+		// OLM namespaces are privileged, but are where OLM expects customers to
+		// subscribe to operators.
+		authz.Deny("privileged-namespace",
+			authz.All(
+				authz.InGroup(responsehelper.DedicatedAdminGroupName),
+				authz.If(p.IsPrivilegedNamespace(namespace) && !p.IsOLMNamespace(namespace)),
+			),
+			fmt.Sprintf("Dedicated admins may not create Subscriptions in privileged namespace %s", namespace)),
+		authz.Allow("rbac", authz.Always(), "RBAC allowed"),
+	}.Evaluate(request.AdmissionRequest.UserInfo)
+	return audit.WithRule(decision.Response(request), decision.Rule)
+}
+
+// HandleRequest Decide if the incoming request is allowed
+func (s *SubscriptionWebhook) HandleRequest(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	request, version, err := utils.ParseHTTPRequest(r)
+	if err != nil {
+		log.Error(err, "Error parsing HTTP Request Body")
+		metrics.RecordDecodeFailure(WebhookName)
+		responsehelper.SendResponse(w, admissionctl.Errored(http.StatusBadRequest, err), version)
+		return
+	}
+	// Is this a valid request?
+	if !s.Validate(request) {
+		resp := admissionctl.Errored(http.StatusBadRequest,
+			fmt.Errorf("Could not parse Subscription from request"))
+		resp.UID = request.AdmissionRequest.UID
+		metrics.RecordResponse(WebhookName, request, resp, start)
+		audit.Record(WebhookName, request, resp)
+		responsehelper.SendResponse(w, resp, version)
+		return
+	}
+	// should the request be authorized?
+	resp, warnings := enforcement.Apply(WebhookName, s.authorized(request))
+	metrics.RecordResponse(WebhookName, request, resp, start)
+	audit.Record(WebhookName, request, resp)
+	responsehelper.SendResponse(w, resp, version, warnings...)
+}
+
+// NewWebhook creates a new webhook
+func NewWebhook() *SubscriptionWebhook {
+	scheme := runtime.NewScheme()
+	v1beta1.AddToScheme(scheme)
+
+	return &SubscriptionWebhook{
+		s: scheme,
+	}
+}
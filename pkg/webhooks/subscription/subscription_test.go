@@ -0,0 +1,191 @@
+package subscription
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/lisa/k8s-webhook-framework/pkg/testutils"
+
+	"k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+const (
+	fixtureDir string = "../../../fixtures/subscriptions"
+
+	testSubscriptionRaw string = `{
+  "metadata": {
+    "name": "%s",
+    "namespace": "%s",
+    "uid": "%s",
+    "creationTimestamp": "2020-05-10T07:51:00Z"
+  }
+}`
+)
+
+type subscriptionTestSuites struct {
+	testID          string
+	targetNamespace string
+	username        string
+	userGroups      []string
+	operation       v1beta1.Operation
+	shouldBeAllowed bool
+}
+
+func runSubscriptionTests(t *testing.T, tests []subscriptionTestSuites) {
+	gvk := metav1.GroupVersionKind{
+		Group:   "operators.coreos.com",
+		Version: "v1alpha1",
+		Kind:    "Subscription",
+	}
+	gvr := metav1.GroupVersionResource{
+		Group:    "operators.coreos.com",
+		Version:  "v1alpha1",
+		Resource: "subscriptions",
+	}
+
+	for _, test := range tests {
+		rawObjString := fmt.Sprintf(testSubscriptionRaw, "mysub", test.targetNamespace, test.testID)
+		obj := runtime.RawExtension{
+			Raw: []byte(rawObjString),
+		}
+		hook := NewWebhook()
+		httprequest, err := testutils.CreateHTTPRequest(hook.GetURI(),
+			test.testID,
+			gvk, gvr, test.operation, test.username, test.userGroups, obj)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+
+		response, err := testutils.SendHTTPRequest(httprequest, hook)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if response.UID == "" {
+			t.Fatalf("No tracking UID associated with the response.")
+		}
+
+		if response.Allowed != test.shouldBeAllowed {
+			t.Fatalf("Mismatch: %s (groups=%s) %s %s a Subscription in the %s namespace. Test's expectation is that the user %s", test.username, test.userGroups, testutils.CanCanNot(response.Allowed), string(test.operation), test.targetNamespace, testutils.CanCanNot(test.shouldBeAllowed))
+		}
+	}
+}
+
+// TestFixtures replays the captured AdmissionReviews in fixtures/subscriptions
+func TestFixtures(t *testing.T) {
+	testutils.RunFixtures(t, NewWebhook(), fixtureDir)
+}
+
+func TestAdmins(t *testing.T) {
+	tests := []subscriptionTestSuites{
+		{
+			testID:          "sre-create-priv-sub",
+			targetNamespace: "redhat-layered-product",
+			username:        "test-user",
+			userGroups:      []string{"osd-sre-admins", "dedicated-admins", "system:authenticated", "system:authenticated:oauth"},
+			operation:       v1beta1.Create,
+			shouldBeAllowed: true,
+		},
+		{
+			testID:          "kubeadmin-create-priv-sub",
+			targetNamespace: "openshift-operators",
+			username:        "kube:admin",
+			userGroups:      []string{"system:authenticated"},
+			operation:       v1beta1.Create,
+			shouldBeAllowed: true,
+		},
+	}
+	runSubscriptionTests(t, tests)
+}
+
+func TestDedicatedAdmins(t *testing.T) {
+	tests := []subscriptionTestSuites{
+		{
+			testID:          "dedi-create-nonpriv-sub",
+			targetNamespace: "my-ns",
+			username:        "test-user",
+			userGroups:      []string{"dedicated-admins", "system:authenticated", "system:authenticated:oauth"},
+			operation:       v1beta1.Create,
+			shouldBeAllowed: true,
+		},
+		{
+			testID:          "dedi-create-olm-sub",
+			targetNamespace: "openshift-operators",
+			username:        "test-user",
+			userGroups:      []string{"dedicated-admins", "system:authenticated", "system:authenticated:oauth"},
+			operation:       v1beta1.Create,
+			shouldBeAllowed: true,
+		},
+		{
+			testID:          "dedi-create-openshift-sub",
+			targetNamespace: "openshift-monitoring",
+			username:        "test-user",
+			userGroups:      []string{"dedicated-admins", "system:authenticated", "system:authenticated:oauth"},
+			operation:       v1beta1.Create,
+			shouldBeAllowed: false,
+		},
+		{
+			testID:          "dedi-create-kube-sub",
+			targetNamespace: "kube-system",
+			username:        "test-user",
+			userGroups:      []string{"dedicated-admins", "system:authenticated", "system:authenticated:oauth"},
+			operation:       v1beta1.Create,
+			shouldBeAllowed: false,
+		},
+		{
+			testID:          "dedi-create-redhat-sub",
+			targetNamespace: "redhat-layered-product",
+			username:        "test-user",
+			userGroups:      []string{"dedicated-admins", "system:authenticated", "system:authenticated:oauth"},
+			operation:       v1beta1.Create,
+			shouldBeAllowed: false,
+		},
+	}
+	runSubscriptionTests(t, tests)
+}
+
+func TestNormalUser(t *testing.T) {
+	tests := []subscriptionTestSuites{
+		{
+			// RBAC is left to decide for everyone else
+			testID:          "nonpriv-create-nonpriv-sub",
+			targetNamespace: "my-ns",
+			username:        "test-user",
+			userGroups:      []string{"system:authenticated", "system:authenticated:oauth"},
+			operation:       v1beta1.Create,
+			shouldBeAllowed: true,
+		},
+	}
+	runSubscriptionTests(t, tests)
+}
+
+func TestMatchPollicy(t *testing.T) {
+	if NewWebhook().MatchPolicy() == nil {
+		t.Fatalf("nil Match Policy")
+	}
+}
+
+func TestName(t *testing.T) {
+	if NewWebhook().Name() == "" {
+		t.Fatalf("Empty hook name")
+	}
+}
+
+func TestRules(t *testing.T) {
+	if len(NewWebhook().Rules()) == 0 {
+		t.Log("No rules for this webhook?")
+	}
+}
+
+func TestGetURI(t *testing.T) {
+	if NewWebhook().GetURI()[0] != '/' {
+		t.Fatalf("Hook URI does not begin with a /")
+	}
+}
+
+func TestSideEffects(t *testing.T) {
+	if NewWebhook().SideEffects() == nil {
+		t.Fatalf("nil side effects")
+	}
+}
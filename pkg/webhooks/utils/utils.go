@@ -1,12 +1,15 @@
 package utils
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 
+	admissionv1 "k8s.io/api/admission/v1"
 	"k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/serializer"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
@@ -15,6 +18,14 @@ import (
 
 const validContentType string = "application/json"
 
+// CustomerNamespaceLabel marks a namespace as belonging to the customer. Hooks
+// that only guard privileged namespaces use it, through
+// PrivilegedNamespaceSelector, to have the API server skip customer namespaces
+// altogether. Only admins may update privileged namespaces (see the
+// namespace-validation hook), so a dedicated admin cannot use the label to
+// exempt one.
+const CustomerNamespaceLabel string = "managed.openshift.io/customer-namespace"
+
 var (
 	admissionScheme = runtime.NewScheme()
 	admissionCodecs = serializer.NewCodecFactory(admissionScheme)
@@ -29,51 +40,105 @@ func SliceContains(needle string, haystack []string) bool {
 	return false
 }
 
-func ParseHTTPRequest(r *http.Request) (admissionctl.Request, admissionctl.Response, error) {
-	var resp admissionctl.Response
+// PrivilegedNamespaceSelector selects every namespace not labelled with
+// CustomerNamespaceLabel. Privileged namespaces are recognised by name, which a
+// label selector cannot express, so this errs on the side of sending the hook
+// too many requests rather than too few.
+func PrivilegedNamespaceSelector() *metav1.LabelSelector {
+	return &metav1.LabelSelector{
+		MatchExpressions: []metav1.LabelSelectorRequirement{
+			{Key: CustomerNamespaceLabel, Operator: metav1.LabelSelectorOpDoesNotExist},
+		},
+	}
+}
+
+// ParseHTTPRequest decodes the AdmissionReview in the body of r. Both
+// admission.k8s.io/v1 and admission.k8s.io/v1beta1 reviews are accepted; the
+// returned string is the apiVersion the API server used, which must be handed
+// to SendResponse so the reply is sent back in the same version. Reviews
+// without an apiVersion are treated as v1beta1.
+func ParseHTTPRequest(r *http.Request) (admissionctl.Request, string, error) {
 	var req admissionctl.Request
 	var err error
 	var body []byte
+	version := v1beta1.SchemeGroupVersion.String()
 	if r.Body != nil {
 		if body, err = ioutil.ReadAll(r.Body); err != nil {
-			resp = admissionctl.Errored(http.StatusBadRequest, err)
-			return req, resp, err
+			return req, version, err
 		}
 	} else {
-		err := errors.New("request body is nil")
-		resp = admissionctl.Errored(http.StatusBadRequest, err)
-		return req, resp, err
+		return req, version, errors.New("request body is nil")
 	}
 	if len(body) == 0 {
-		err := errors.New("request body is empty")
-		resp = admissionctl.Errored(http.StatusBadRequest, err)
-		return req, resp, err
+		return req, version, errors.New("request body is empty")
 	}
 	contentType := r.Header.Get("Content-Type")
 	if contentType != validContentType {
-		err := fmt.Errorf("contentType=%s, expected application/json", contentType)
-		resp = admissionctl.Errored(http.StatusBadRequest, err)
-		return req, resp, err
+		return req, version, fmt.Errorf("contentType=%s, expected application/json", contentType)
 	}
-	ar := v1beta1.AdmissionReview{}
-	if _, _, err := admissionCodecs.UniversalDeserializer().Decode(body, nil, &ar); err != nil {
-		resp = admissionctl.Errored(http.StatusBadRequest, err)
-		return req, resp, err
+
+	typeMeta := metav1.TypeMeta{}
+	if err := json.Unmarshal(body, &typeMeta); err != nil {
+		return req, version, err
+	}
+	if typeMeta.APIVersion != "" {
+		version = typeMeta.APIVersion
 	}
 
-	// Copy for tracking
-	if ar.Request == nil {
-		err = fmt.Errorf("No request in request body")
-		resp = admissionctl.Errored(http.StatusBadRequest, err)
-		return req, resp, err
+	switch version {
+	case admissionv1.SchemeGroupVersion.String():
+		ar := admissionv1.AdmissionReview{}
+		if _, _, err := admissionCodecs.UniversalDeserializer().Decode(body, nil, &ar); err != nil {
+			return req, version, err
+		}
+		if ar.Request == nil {
+			return req, version, fmt.Errorf("No request in request body")
+		}
+		req = admissionctl.Request{
+			AdmissionRequest: v1AdmissionRequestToV1beta1(ar.Request),
+		}
+	case v1beta1.SchemeGroupVersion.String():
+		ar := v1beta1.AdmissionReview{}
+		if _, _, err := admissionCodecs.UniversalDeserializer().Decode(body, nil, &ar); err != nil {
+			return req, version, err
+		}
+		if ar.Request == nil {
+			return req, version, fmt.Errorf("No request in request body")
+		}
+		req = admissionctl.Request{
+			AdmissionRequest: *ar.Request,
+		}
+	default:
+		// Answer in a version the caller is guaranteed to understand
+		return req, v1beta1.SchemeGroupVersion.String(), fmt.Errorf("Unsupported AdmissionReview version %s", version)
 	}
-	resp.UID = ar.Request.UID
-	req = admissionctl.Request{
-		AdmissionRequest: *ar.Request,
+	return req, version, nil
+}
+
+// v1AdmissionRequestToV1beta1 copies an admission.k8s.io/v1 AdmissionRequest
+// into the v1beta1 type that controller-runtime's admission.Request embeds.
+// The two versions carry identical fields.
+func v1AdmissionRequestToV1beta1(in *admissionv1.AdmissionRequest) v1beta1.AdmissionRequest {
+	return v1beta1.AdmissionRequest{
+		UID:                in.UID,
+		Kind:               in.Kind,
+		Resource:           in.Resource,
+		SubResource:        in.SubResource,
+		RequestKind:        in.RequestKind,
+		RequestResource:    in.RequestResource,
+		RequestSubResource: in.RequestSubResource,
+		Name:               in.Name,
+		Namespace:          in.Namespace,
+		Operation:          v1beta1.Operation(in.Operation),
+		UserInfo:           in.UserInfo,
+		Object:             in.Object,
+		OldObject:          in.OldObject,
+		DryRun:             in.DryRun,
+		Options:            in.Options,
 	}
-	return req, resp, nil
 }
 
 func init() {
 	utilruntime.Must(v1beta1.AddToScheme(admissionScheme))
+	utilruntime.Must(admissionv1.AddToScheme(admissionScheme))
 }
@@ -0,0 +1,105 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/api/admission/v1beta1"
+)
+
+const testReviewRaw string = `{
+  %s
+  "request": {
+    "uid": "test-uid",
+    "kind": {
+      "group": "",
+      "version": "v1",
+      "kind": "Namespace"
+    },
+    "resource": {
+      "group": "",
+      "version": "v1",
+      "resource": "namespaces"
+    },
+    "operation": "CREATE",
+    "userInfo": {
+      "username": "test-user",
+      "groups": ["system:authenticated"]
+    },
+    "object": {
+      "metadata": {
+        "name": "my-ns"
+      }
+    }
+  }
+}`
+
+func TestParseHTTPRequestVersions(t *testing.T) {
+	tests := []struct {
+		typeMeta        string
+		expectedVersion string
+		shouldError     bool
+	}{
+		{
+			typeMeta:        `"kind": "AdmissionReview", "apiVersion": "admission.k8s.io/v1beta1",`,
+			expectedVersion: v1beta1.SchemeGroupVersion.String(),
+		},
+		{
+			typeMeta:        `"kind": "AdmissionReview", "apiVersion": "admission.k8s.io/v1",`,
+			expectedVersion: admissionv1.SchemeGroupVersion.String(),
+		},
+		{
+			// no apiVersion is treated as v1beta1
+			typeMeta:        ``,
+			expectedVersion: v1beta1.SchemeGroupVersion.String(),
+		},
+		{
+			typeMeta:        `"kind": "AdmissionReview", "apiVersion": "admission.k8s.io/v2",`,
+			expectedVersion: v1beta1.SchemeGroupVersion.String(),
+			shouldError:     true,
+		},
+	}
+	for _, test := range tests {
+		body := bytes.NewBufferString(fmt.Sprintf(testReviewRaw, test.typeMeta))
+		httprequest := httptest.NewRequest("POST", "/test", body)
+		httprequest.Header["Content-Type"] = []string{"application/json"}
+
+		req, version, err := ParseHTTPRequest(httprequest)
+		if version != test.expectedVersion {
+			t.Fatalf("Expected version %s, got %s", test.expectedVersion, version)
+		}
+		if test.shouldError {
+			if err == nil {
+				t.Fatalf("Expected an error parsing %s but got none", test.typeMeta)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Unexpected error parsing %s: %s", test.typeMeta, err.Error())
+		}
+		if req.UID != "test-uid" {
+			t.Fatalf("Expected request UID test-uid, got %s", req.UID)
+		}
+		if req.Operation != v1beta1.Create {
+			t.Fatalf("Expected operation %s, got %s", v1beta1.Create, req.Operation)
+		}
+		if req.UserInfo.Username != "test-user" {
+			t.Fatalf("Expected username test-user, got %s", req.UserInfo.Username)
+		}
+		if len(req.Object.Raw) == 0 {
+			t.Fatalf("Expected the request Object to be populated")
+		}
+	}
+}
+
+func TestParseHTTPRequestContentType(t *testing.T) {
+	body := bytes.NewBufferString(fmt.Sprintf(testReviewRaw, ""))
+	httprequest := httptest.NewRequest("POST", "/test", body)
+	httprequest.Header["Content-Type"] = []string{"text/plain"}
+	if _, _, err := ParseHTTPRequest(httprequest); err == nil {
+		t.Fatalf("Expected an error for a non-JSON content type")
+	}
+}